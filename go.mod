@@ -3,16 +3,19 @@ module github.com/yitose/rssviewer
 go 1.19
 
 require (
+	github.com/PuerkitoBio/goquery v1.8.0
+	github.com/andybalholm/cascadia v1.3.1
 	github.com/gdamore/tcell/v2 v2.6.0
 	github.com/mmcdole/gofeed v1.2.1
 	github.com/pkg/errors v0.9.1
 	github.com/rivo/tview v0.0.0-20230307144320-cc10b288e304
 	github.com/skratchdot/open-golang v0.0.0-20200116055534-eef842397966
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/term v0.5.0
+	golang.org/x/text v0.7.0
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.8.0 // indirect
-	github.com/andybalholm/cascadia v1.3.1 // indirect
 	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
@@ -23,6 +26,4 @@ require (
 	github.com/rivo/uniseg v0.4.3 // indirect
 	golang.org/x/net v0.4.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
-	golang.org/x/term v0.5.0 // indirect
-	golang.org/x/text v0.7.0 // indirect
 )
@@ -0,0 +1,508 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	db "github.com/yitose/rssviewer/internal/db"
+	fd "github.com/yitose/rssviewer/internal/feed"
+	"github.com/yitose/rssviewer/internal/refresh"
+	"github.com/yitose/rssviewer/pkg/util"
+	"golang.org/x/term"
+)
+
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(c *Cli, ctx context.Context, args []string) error
+}
+
+var commands = []*command{
+	{"add", "<url|command>...", "subscribe to feeds", (*Cli).add},
+	{"remove", "<url|command>...", "unsubscribe from feeds", (*Cli).remove},
+	{"list", "", "list subscribed feeds", (*Cli).list},
+	{"enable", "<url|command>...", "refresh feeds again which were disabled as gone", (*Cli).enable},
+	{"update", "", "refresh every feed", (*Cli).update},
+	{"daemon", "[-once]", "refresh feeds on their schedule", (*Cli).daemon},
+	{"health", "[-problems]", "show how fetching each feed is going", (*Cli).health},
+	{"group", "add <title> <url>... | rm <title> | list", "manage groups", (*Cli).group},
+	{"import", "[-exec] <path>", "import an OPML file or a list of URLs", (*Cli).importFeeds},
+	{"export", "<path>", "export to an OPML file or a list of URLs", (*Cli).export},
+}
+
+var (
+	ErrUsage       = errors.New("invalid arguments")
+	errInterrupted = errors.New("interrupted")
+)
+
+// Cli runs subcommands against the same store as the TUI, for scripts and
+// cron jobs.
+type Cli struct {
+	Config *db.Config
+	DB     *db.FeedDB
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// Run executes the subcommand in args and returns the exit status.
+func Run(args []string) int {
+	c := &Cli{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		c.usage()
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			return 0
+		}
+		return 2
+	}
+
+	if err := c.load(); err != nil {
+		fmt.Fprintln(c.Stderr, err)
+		return 1
+	}
+
+	// Interrupting a command stops the fetches in flight, keeping the
+	// results which are already in.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.run(c, ctx, args[1:]); err != nil {
+		if err == ErrUsage {
+			fmt.Fprintf(c.Stderr, "usage: rssviewer %s %s\n", cmd.name, cmd.args)
+			return 2
+		}
+		fmt.Fprintln(c.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func (c *Cli) usage() {
+	fmt.Fprintln(c.Stderr, "usage: rssviewer [command] [arguments]")
+	fmt.Fprintln(c.Stderr, "\nWithout a command, the terminal UI is started.\n\ncommands:")
+	w := tabwriter.NewWriter(c.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.short)
+	}
+	w.Flush()
+}
+
+func (c *Cli) load() error {
+	config, err := db.LoadOrNewConfig()
+	if err != nil {
+		return err
+	}
+	c.Config = config
+	c.DB = db.NewDB()
+	if err := c.DB.LoadFeeds(); err != nil {
+		if _, ok := err.(*db.LoadError); !ok {
+			return err
+		}
+		fmt.Fprintln(c.Stderr, err)
+	}
+	return nil
+}
+
+func (c *Cli) add(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	fs.SetOutput(c.Stderr)
+	color := fs.Int("color", 0, "color code of the feed")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 {
+		return ErrUsage
+	}
+
+	failed := 0
+	subs := []*db.Subscription{}
+	groups := []*fd.Group{}
+	for _, url := range fs.Args() {
+		// A feed file or directory may be given relative to where we are.
+		if !filepath.IsAbs(url) && util.IsFile(url) {
+			if abs, err := filepath.Abs(url); err == nil {
+				url = abs
+			}
+		}
+		expanded, g, err := db.ExpandSubscription(&db.Subscription{URL: url, Color: *color})
+		if err != nil {
+			fmt.Fprintf(c.Stderr, "%s: %s\n", url, err)
+			failed++
+			continue
+		}
+		if g != nil {
+			groups = append(groups, g)
+		}
+		for _, s := range expanded {
+			if c.DB.GetFeed(s.URL) != nil {
+				fmt.Fprintf(c.Stderr, "%s: %s\n", s.URL, db.ErrFeedExists)
+				failed++
+				continue
+			}
+			subs = append(subs, s)
+		}
+	}
+
+	failed += c.addFeeds(ctx, subs, func(f *fd.Feed) {
+		fmt.Fprintf(c.Stdout, "added %s (%s)\n", f.Title, f.FeedLink)
+	})
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	if err := c.DB.AddImportedGroups(groups); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		fmt.Fprintf(c.Stdout, "grouped the feeds of the mailbox as %s\n", g.Title)
+	}
+	return failedErr(failed, "feeds could not be added")
+}
+
+// addFeeds fetches and saves the feeds of subs, calling added for each new
+// feed, and returns how many failed.
+func (c *Cli) addFeeds(ctx context.Context, subs []*db.Subscription, added func(f *fd.Feed)) int {
+	jobs := []*refresh.Job{}
+	for _, s := range subs {
+		jobs = append(jobs, c.Config.NewFeedJob(s, c.Config.RandomColor()))
+	}
+
+	failed := 0
+	c.Config.Engine().Run(ctx, jobs, func(r *refresh.Result) {
+		if r.Err == nil {
+			r.Err = c.DB.InsertFeed(r.Feed)
+			if r.Err == db.ErrFeedExists {
+				// Listed twice.
+				return
+			}
+		}
+		if r.Err != nil {
+			fmt.Fprintf(c.Stderr, "%s: %s\n", r.Job.Link, r.Err)
+			var multi *fd.MultipleFeedsError
+			if errors.As(r.Err, &multi) {
+				fmt.Fprintln(c.Stderr, "add one of them instead:")
+				for _, cand := range multi.Candidates {
+					fmt.Fprintf(c.Stderr, "  %s (%s)\n", cand.URL, cand.Title)
+				}
+			}
+			failed++
+			return
+		}
+		added(r.Feed)
+	})
+	return failed
+}
+
+// addedSubscriptions returns the subscriptions in subs whose feeds have been
+// added.
+func (c *Cli) addedSubscriptions(subs []*db.Subscription) []*db.Subscription {
+	added := []*db.Subscription{}
+	for _, s := range subs {
+		if c.DB.GetFeed(s.URL) != nil {
+			added = append(added, s)
+		}
+	}
+	return added
+}
+
+func (c *Cli) remove(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	failed := 0
+	for _, url := range args {
+		f := c.DB.GetFeed(url)
+		if f == nil {
+			fmt.Fprintf(c.Stderr, "%s: not subscribed\n", url)
+			failed++
+			continue
+		}
+		if err := c.DB.DeleteFeed(f); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Stdout, "removed %s (%s)\n", f.Title, f.FeedLink)
+	}
+	return failedErr(failed, "feeds could not be removed")
+}
+
+func (c *Cli) enable(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	failed := 0
+	for _, url := range args {
+		f := c.DB.GetFeed(url)
+		if f == nil {
+			fmt.Fprintf(c.Stderr, "%s: not subscribed\n", url)
+			failed++
+			continue
+		}
+		if err := db.ModifyFeed(f, func(f *fd.Feed) { f.Disabled = false }); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Stdout, "enabled %s (%s)\n", f.Title, f.FeedLink)
+	}
+	return failedErr(failed, "feeds could not be enabled")
+}
+
+func (c *Cli) list(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+
+	w := tabwriter.NewWriter(c.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TITLE\tUNREAD\tITEMS\tURL")
+	for _, f := range c.DB.Feed {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", f.Title, f.UnreadCount(), len(f.Items), f.FeedLink)
+	}
+	return w.Flush()
+}
+
+func (c *Cli) update(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return ErrUsage
+	}
+
+	updated, failed := c.refreshFeeds(ctx, c.DB.Feed, func(f *fd.Feed, note string, err error) {
+		if err != nil {
+			fmt.Fprintf(c.Stderr, "%s: %s\n", f.FeedLink, err)
+		}
+		if note != "" {
+			fmt.Fprintln(c.Stdout, note)
+		}
+	})
+	fmt.Fprintf(c.Stdout, "updated %d feeds\n", updated)
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	return failedErr(failed, "feeds could not be updated")
+}
+
+// refreshFeeds fetches feeds again and saves the results, calling done with
+// each feed as it is saved, or with the error if it could not be fetched, in
+// which case the failure is saved instead. note tells whether the feed has
+// moved or is gone. Disabled feeds are skipped.
+func (c *Cli) refreshFeeds(ctx context.Context, feeds []*fd.Feed, done func(f *fd.Feed, note string, err error)) (updated, failed int) {
+	jobs := []*refresh.Job{}
+	for _, f := range feeds {
+		if !f.Disabled {
+			jobs = append(jobs, c.Config.UpdateJob(f))
+		}
+	}
+
+	c.Config.Engine().Run(ctx, jobs, func(r *refresh.Result) {
+		link := r.Job.Link
+		old := c.DB.GetFeed(link)
+		f, err := r.Feed, r.Err
+		note := ""
+		if err == nil {
+			f, err = c.DB.ApplyUpdate(old, f, r.Fetch, c.Config.Retention(link))
+			if err == nil && f.FeedLink != link {
+				note = fmt.Sprintf("%s has moved to %s", link, f.FeedLink)
+				err = c.Config.MoveFeed(link, f.FeedLink)
+			}
+		} else if stored, saveErr := c.DB.RecordFailure(old, r.Fetch); saveErr != nil {
+			err = errors.Wrap(saveErr, err.Error())
+		} else {
+			old = stored
+		}
+
+		if err != nil {
+			f = old
+			failed++
+		} else {
+			updated++
+		}
+		if f.Disabled {
+			note = fmt.Sprintf("%s is gone and has been disabled", link)
+		}
+		done(f, note, err)
+	})
+	return updated, failed
+}
+
+func (c *Cli) health(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("health", flag.ContinueOnError)
+	fs.SetOutput(c.Stderr)
+	problems := fs.Bool("problems", false, "only list failing feeds and feeds which have gone silent")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return ErrUsage
+	}
+
+	w := tabwriter.NewWriter(c.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TITLE\tLAST FETCH\tSTATUS\tLATENCY\tSIZE\tFAILURES\tLAST ITEM\tURL\tLAST ERROR")
+	for _, f := range c.DB.Feed {
+		if *problems && !c.Config.HasProblem(f) {
+			continue
+		}
+		lastFetch, status, latency, size := "-", "-", "-", "-"
+		if fetch := f.LastFetch(); fetch != nil {
+			lastFetch = fd.FormatDate(&fetch.At)
+			status = fetch.StatusText()
+			latency = fetch.Latency.Round(time.Millisecond).String()
+			size = fd.FormatSize(fetch.Size)
+		}
+		lastItem := "-"
+		if last := f.LastPublished(); last != nil {
+			lastItem = fd.FormatDate(last)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", f.Title, lastFetch, status, latency, size, f.FailureCount, lastItem, f.FeedLink, f.LastError)
+	}
+	return w.Flush()
+}
+
+func (c *Cli) group(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 3 {
+			return ErrUsage
+		}
+		title := args[1]
+		if title == db.TodaysFeedTitle {
+			return errors.Errorf("%s is an automatically generated group", title)
+		}
+		feeds := []*fd.Feed{}
+		for _, url := range args[2:] {
+			f := c.DB.GetFeed(url)
+			if f == nil {
+				return errors.Errorf("%s: not subscribed", url)
+			}
+			feeds = append(feeds, f)
+		}
+		return c.DB.AddOrUpdateGroup(fd.MergeFeeds(feeds, title))
+	case "rm":
+		if len(args) != 2 {
+			return ErrUsage
+		}
+		g := c.DB.GetGroup(args[1])
+		if g == nil {
+			return errors.Errorf("%s: no such group", args[1])
+		}
+		return c.DB.DeleteGroup(g)
+	case "list":
+		if len(args) != 1 {
+			return ErrUsage
+		}
+		for _, g := range c.DB.Group {
+			fmt.Fprintf(c.Stdout, "%s (%d unread)\n", g.Title, c.DB.UnreadCount(g))
+			for _, link := range g.FeedLinks {
+				fmt.Fprintf(c.Stdout, "  %s\n", link)
+			}
+		}
+		return nil
+	}
+	return ErrUsage
+}
+
+func (c *Cli) importFeeds(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(c.Stderr)
+	allowExec := fs.Bool("exec", false, "add command feeds without asking")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return ErrUsage
+	}
+	path := fs.Arg(0)
+	if !util.IsFile(path) {
+		return errors.Errorf("%s: file not found", path)
+	}
+
+	subs, groups, err := db.ReadSubscriptions(path)
+	if err != nil {
+		return err
+	}
+
+	newSubs := []*db.Subscription{}
+	for _, s := range subs {
+		if c.DB.GetFeed(s.URL) == nil {
+			newSubs = append(newSubs, s)
+		}
+	}
+	skipped := 0
+	if cmds := db.CommandSubscriptions(newSubs); len(cmds) > 0 && !*allowExec && !c.confirmCommands(cmds) {
+		skipped = len(cmds)
+		fmt.Fprintf(c.Stderr, "skipping %d command feeds\n", skipped)
+		newSubs = db.WithoutCommands(newSubs)
+	}
+
+	failed := c.addFeeds(ctx, newSubs, func(f *fd.Feed) {})
+	if err := c.Config.AddRules(c.addedSubscriptions(newSubs)); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	if err := c.DB.AddImportedGroups(groups); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "imported %d feeds from %s\n", len(subs)-failed-skipped, path)
+	return failedErr(failed, "feeds could not be imported")
+}
+
+// confirmCommands asks whether the command feeds in cmds may be added. Without
+// a terminal to ask on, they may not.
+func (c *Cli) confirmCommands(cmds []*db.Subscription) bool {
+	if f, ok := c.Stdin.(*os.File); !ok || !isTerminal(f) {
+		fmt.Fprintln(c.Stderr, "the import has command feeds; use -exec to add them")
+		return false
+	}
+
+	fmt.Fprintln(c.Stderr, "the import has command feeds, which will be run on every refresh:")
+	for _, s := range cmds {
+		fmt.Fprintf(c.Stderr, "  %s\n", fd.CommandOf(s.URL))
+	}
+	fmt.Fprint(c.Stderr, "add them? [y/N] ")
+	answer, _ := bufio.NewReader(c.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+func (c *Cli) export(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+	if err := c.DB.Export(args[0], c.Config); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "exported %d feeds to %s\n", len(c.DB.Feed), args[0])
+	return nil
+}
+
+func failedErr(n int, msg string) error {
+	if n == 0 {
+		return nil
+	}
+	return errors.Errorf("%d %s", n, msg)
+}
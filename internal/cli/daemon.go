@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	fd "github.com/yitose/rssviewer/internal/feed"
+)
+
+// maxDaemonSleep bounds how long the daemon waits before reloading the
+// store, so that feeds added from the TUI or the command line are picked up.
+const maxDaemonSleep = time.Minute
+
+func (c *Cli) daemon(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	fs.SetOutput(c.Stderr)
+	once := fs.Bool("once", false, "refresh the feeds which are due and exit")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return ErrUsage
+	}
+
+	logger := log.New(c.Stderr, "", log.LstdFlags)
+	logger.Println("daemon started")
+
+	for {
+		next, err := c.refreshDueFeeds(ctx, logger)
+		if err != nil {
+			return err
+		}
+		if *once {
+			return nil
+		}
+
+		sleep := time.Until(next)
+		if sleep > maxDaemonSleep {
+			sleep = maxDaemonSleep
+		}
+		select {
+		case <-ctx.Done():
+			logger.Println("daemon stopped")
+			return nil
+		case <-time.After(sleep):
+		}
+	}
+}
+
+// refreshDueFeeds reloads the config and the store, fetches the feeds which
+// are due, and returns when the next feed will be.
+func (c *Cli) refreshDueFeeds(ctx context.Context, logger *log.Logger) (time.Time, error) {
+	if err := c.load(); err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	next := now.Add(maxDaemonSleep)
+	due := []*fd.Feed{}
+	for _, f := range c.DB.Feed {
+		if f.Disabled {
+			continue
+		}
+		if t := c.Config.NextUpdate(f); t.After(now) {
+			if t.Before(next) {
+				next = t
+			}
+			continue
+		}
+		due = append(due, f)
+	}
+
+	c.refreshFeeds(ctx, due, func(f *fd.Feed, note string, err error) {
+		if err != nil {
+			logger.Printf("%s: %s", f.FeedLink, err)
+		} else {
+			logger.Printf("refreshed %s", f.FeedLink)
+		}
+		if note != "" {
+			logger.Println(note)
+		}
+		if t := c.Config.NextUpdate(f); t.Before(next) {
+			next = t
+		}
+	})
+
+	return next, nil
+}
@@ -2,14 +2,69 @@ package db
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yitose/rssviewer/internal/color"
+	fd "github.com/yitose/rssviewer/internal/feed"
+	"github.com/yitose/rssviewer/internal/refresh"
+	"github.com/yitose/rssviewer/internal/secret"
 
 	"github.com/yitose/rssviewer/pkg/util"
 )
 
 type Config struct {
-	Color *ColorConfig `json:"color"`
+	Version int          `json:"version"`
+	Color   *ColorConfig `json:"color"`
+	// AutoRefresh makes the TUI refresh feeds in the background when they
+	// are due, rather than only at startup and on demand.
+	AutoRefresh bool                   `json:"autoRefresh"`
+	Refresh     *RefreshConfig         `json:"refresh"`
+	Health      *HealthConfig          `json:"health"`
+	HTTP        *HTTPConfig            `json:"http,omitempty"`
+	Command     *CommandConfig         `json:"command"`
+	Feed        *FeedConfig            `json:"feed"`
+	Feeds       map[string]*FeedConfig `json:"feeds,omitempty"`
+	// Shorthands are tried before the built-in ones, like gh:OWNER/REPO.
+	Shorthands []*ShorthandConfig `json:"shorthands,omitempty"`
+}
+
+// ShorthandConfig expands links starting with name and a colon into url.
+// "{0}" in url is the rest of the link, and "{1}", "{2}" and so on are the
+// submatches of pattern, a regular expression the rest must match.
+type ShorthandConfig struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern,omitempty"`
+	URL     string `json:"url"`
+}
+
+func (c *Config) shorthands() []*fd.Shorthand {
+	shorthands := []*fd.Shorthand{}
+	for _, sc := range c.Shorthands {
+		shorthands = append(shorthands, &fd.Shorthand{Name: sc.Name, Pattern: sc.Pattern, URL: sc.URL})
+	}
+	return shorthands
+}
+
+// RefreshConfig limits how hard feeds are fetched: from how many hosts at
+// once, how long to wait between requests to the same host, and how long a
+// single fetch may take.
+type RefreshConfig struct {
+	Concurrency      int `json:"concurrency"`
+	HostDelaySeconds int `json:"hostDelaySeconds"`
+	TimeoutSeconds   int `json:"timeoutSeconds"`
+}
+
+// CommandConfig sets how command feeds are run: in Dir, which is the home
+// directory if empty, with Env added to a minimal environment, and for at
+// most TimeoutSeconds.
+type CommandConfig struct {
+	Dir            string            `json:"dir,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`
+	TimeoutSeconds int               `json:"timeoutSeconds"`
 }
 
 type ColorConfig struct {
@@ -22,23 +77,143 @@ type ColorConfig struct {
 	MinLightness int  `json:"minLightness"`
 }
 
+// HealthConfig sets when a feed counts as a problem: after failing
+// MaxFailures times in a row, or publishing nothing for MaxSilentDays.
+// 0 disables the check.
+type HealthConfig struct {
+	MaxFailures   int `json:"maxFailures"`
+	MaxSilentDays int `json:"maxSilentDays"`
+}
+
+// FeedConfig holds settings for feeds. The "feed" entry applies to every feed,
+// and entries in "feeds" keyed by a feed URL override it for that feed.
+// The rules which turn a fetched document into a feed are per-feed only.
+// 0 means "not set" in per-feed entries and "unlimited" in the global one;
+// use -1 to lift a global limit for a single feed.
+type FeedConfig struct {
+	RetentionDays int `json:"retentionDays,omitempty"`
+	MaxItems      int `json:"maxItems,omitempty"`
+	// RefreshMinutes is how often the daemon fetches a feed. A per-feed
+	// value takes precedence over the feed's own <ttl>.
+	RefreshMinutes int         `json:"refreshMinutes,omitempty"`
+	HTTP           *HTTPConfig `json:"http,omitempty"`
+	// Filter is a shell command which gets the fetched feed on stdin and
+	// writes the feed to be parsed on stdout. It is run like a command feed,
+	// and refused in the "feed" entry.
+	Filter string `json:"filter,omitempty"`
+	// Scrape makes a feed of a web page without one, JSON of a JSON
+	// document, and Sitemap of the pages of a sitemap. They only apply to
+	// entries in "feeds".
+	Scrape  *ScrapeConfig  `json:"scrape,omitempty"`
+	JSON    *JSONConfig    `json:"json,omitempty"`
+	Sitemap *SitemapConfig `json:"sitemap,omitempty"`
+}
+
+// ScrapeConfig holds the CSS selectors which pick the items of a web page
+// out, and the layouts of its dates in Go's time format. Selectors other
+// than item are matched within each item.
+type ScrapeConfig struct {
+	Item        string   `json:"item"`
+	Title       string   `json:"title,omitempty"`
+	Link        string   `json:"link,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	DateLayouts []string `json:"dateLayouts,omitempty"`
+	Summary     string   `json:"summary,omitempty"`
+}
+
+// JSONConfig holds the paths of the fields of each item in a JSON document,
+// like "$.data.releases[*]" for items and "author.login" for author within
+// an item. feedTitle is a path from the top of the document.
+type JSONConfig struct {
+	Items       string   `json:"items"`
+	Title       string   `json:"title,omitempty"`
+	Link        string   `json:"link,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	DateLayouts []string `json:"dateLayouts,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	Description string   `json:"description,omitempty"`
+	ID          string   `json:"id,omitempty"`
+	FeedTitle   string   `json:"feedTitle,omitempty"`
+}
+
+// SitemapConfig holds regular expressions for the URLs of the pages of a
+// sitemap to include, if there are any, and to exclude.
+type SitemapConfig struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func (sc *SitemapConfig) rules() *fd.SitemapRules {
+	return &fd.SitemapRules{Include: sc.Include, Exclude: sc.Exclude}
+}
+
+func (jc *JSONConfig) rules() *fd.JSONRules {
+	return &fd.JSONRules{
+		Items:       jc.Items,
+		Title:       jc.Title,
+		Link:        jc.Link,
+		Date:        jc.Date,
+		DateLayouts: jc.DateLayouts,
+		Author:      jc.Author,
+		Description: jc.Description,
+		ID:          jc.ID,
+		FeedTitle:   jc.FeedTitle,
+	}
+}
+
+func (sc *ScrapeConfig) rules() *fd.ScrapeRules {
+	return &fd.ScrapeRules{
+		Item:        sc.Item,
+		Title:       sc.Title,
+		Link:        sc.Link,
+		Date:        sc.Date,
+		DateLayouts: sc.DateLayouts,
+		Summary:     sc.Summary,
+	}
+}
+
+// HTTPConfig sets how feeds are requested. The "http" entry of a feed
+// overrides the global one field by field, and adds to its headers.
+// password and bearerToken refer to a secret as env:NAME, file:PATH or
+// cmd:COMMAND rather than holding it, and header values may do the same.
+type HTTPConfig struct {
+	UserAgent      string            `json:"userAgent,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Username       string            `json:"username,omitempty"`
+	Password       string            `json:"password,omitempty"`
+	BearerToken    string            `json:"bearerToken,omitempty"`
+	Proxy          string            `json:"proxy,omitempty"`
+	TimeoutSeconds int               `json:"timeoutSeconds,omitempty"`
+	CAFile         string            `json:"caFile,omitempty"`
+	CertFile       string            `json:"certFile,omitempty"`
+	KeyFile        string            `json:"keyFile,omitempty"`
+}
+
 const (
-	defaultEnablePaint  = true
-	defaultMaxHue       = 360
-	defaultMinHue       = 0
-	defaultMaxSaturatio = 100
-	defaultMinSaturatio = 30
-	defaultMaxLightness = 100
-	defaultMinLightness = 60
+	defaultEnablePaint   = true
+	defaultAutoRefresh   = true
+	defaultMaxHue        = 360
+	defaultMinHue        = 0
+	defaultMaxSaturatio  = 100
+	defaultMinSaturatio  = 30
+	defaultMaxLightness  = 100
+	defaultMinLightness  = 60
+	defaultMaxItems      = 1000
+	defaultRefreshMins   = 30
+	defaultConcurrency   = 8
+	defaultHostDelaySecs = 1
+	defaultTimeoutSecs   = 30
+	defaultMaxFailures   = 3
+	defaultMaxSilentDays = 90
+	defaultCommandSecs   = 30
 )
 
-func LoadOrNewConfig() *Config {
-	config, err := loadConfig(ConfigPath)
-	if err != nil {
-		config = newConfig()
-		SaveConfig(config)
+func LoadOrNewConfig() (*Config, error) {
+	if !util.IsFile(ConfigPath) {
+		config := newConfig()
+		return config, SaveConfig(config)
 	}
-	return config
+	return loadConfig(ConfigPath)
 }
 
 func SaveConfig(config *Config) error {
@@ -50,6 +225,8 @@ func SaveConfig(config *Config) error {
 // L is 50 to 100
 func newConfig() *Config {
 	config := &Config{
+		Version:     ConfigVersion,
+		AutoRefresh: defaultAutoRefresh,
 		Color: &ColorConfig{
 			EnablePaint:  defaultEnablePaint,
 			MaxHue:       defaultMaxHue,
@@ -59,22 +236,337 @@ func newConfig() *Config {
 			MaxLightness: defaultMaxLightness,
 			MinLightness: defaultMinLightness,
 		},
+		Refresh: &RefreshConfig{
+			Concurrency:      defaultConcurrency,
+			HostDelaySeconds: defaultHostDelaySecs,
+			TimeoutSeconds:   defaultTimeoutSecs,
+		},
+		Health: &HealthConfig{
+			MaxFailures:   defaultMaxFailures,
+			MaxSilentDays: defaultMaxSilentDays,
+		},
+		Command: &CommandConfig{
+			TimeoutSeconds: defaultCommandSecs,
+		},
+		Feed: &FeedConfig{
+			MaxItems:       defaultMaxItems,
+			RefreshMinutes: defaultRefreshMins,
+		},
 	}
 	return config
 }
 
+// RefreshInterval returns how often the feed at link is fetched, and whether
+// the interval was set for this feed in particular.
+func (c *Config) RefreshInterval(link string) (time.Duration, bool) {
+	if fc, ok := c.Feeds[link]; ok && fc.RefreshMinutes > 0 {
+		return time.Duration(fc.RefreshMinutes) * time.Minute, true
+	}
+	minutes := c.Feed.RefreshMinutes
+	if minutes <= 0 {
+		minutes = defaultRefreshMins
+	}
+	return time.Duration(minutes) * time.Minute, false
+}
+
+// NextUpdate returns when f is due to be fetched again.
+func (c *Config) NextUpdate(f *fd.Feed) time.Time {
+	interval, isPerFeed := c.RefreshInterval(f.FeedLink)
+	return f.NextUpdate(interval, isPerFeed)
+}
+
+// Engine returns a refresh engine with the limits in the config.
+func (c *Config) Engine() *refresh.Engine {
+	rc := c.Refresh
+	if rc == nil {
+		rc = &RefreshConfig{}
+	}
+	e := &refresh.Engine{
+		Concurrency: rc.Concurrency,
+		HostDelay:   time.Duration(rc.HostDelaySeconds) * time.Second,
+		Timeout:     time.Duration(rc.TimeoutSeconds) * time.Second,
+	}
+	if e.Concurrency <= 0 {
+		e.Concurrency = defaultConcurrency
+	}
+	return e
+}
+
+// HasProblem reports whether f is failing or has gone silent.
+func (c *Config) HasProblem(f *fd.Feed) bool {
+	hc := c.Health
+	if hc == nil {
+		hc = &HealthConfig{}
+	}
+	return f.HasProblem(hc.MaxFailures, time.Duration(hc.MaxSilentDays)*24*time.Hour, time.Now())
+}
+
+// MoveFeed carries the settings for the feed at from over to its new link.
+func (c *Config) MoveFeed(from, to string) error {
+	fc, ok := c.Feeds[from]
+	if !ok {
+		return nil
+	}
+	delete(c.Feeds, from)
+	c.Feeds[to] = fc
+	return SaveConfig(c)
+}
+
+// HTTPOptions returns how the feed at link is requested.
+func (c *Config) HTTPOptions(link string) *fd.HTTPOptions {
+	o := &fd.HTTPOptions{Header: map[string]string{}}
+	configs := []*HTTPConfig{c.HTTP}
+	if fc, ok := c.Feeds[link]; ok {
+		configs = append(configs, fc.HTTP)
+	}
+	for _, hc := range configs {
+		if hc == nil {
+			continue
+		}
+		set := func(dst *string, src string) {
+			if src != "" {
+				*dst = src
+			}
+		}
+		set(&o.UserAgent, hc.UserAgent)
+		set(&o.Username, hc.Username)
+		set(&o.Password, hc.Password)
+		set(&o.BearerToken, hc.BearerToken)
+		set(&o.Proxy, hc.Proxy)
+		set(&o.CAFile, hc.CAFile)
+		set(&o.CertFile, hc.CertFile)
+		set(&o.KeyFile, hc.KeyFile)
+		for name, value := range hc.Headers {
+			o.Header[name] = value
+		}
+		if hc.TimeoutSeconds > 0 {
+			o.Timeout = time.Duration(hc.TimeoutSeconds) * time.Second
+		}
+	}
+	return o
+}
+
+// CommandOptions returns how command feeds are run.
+func (c *Config) CommandOptions() *fd.CommandOptions {
+	cc := c.Command
+	if cc == nil {
+		cc = &CommandConfig{}
+	}
+	o := &fd.CommandOptions{
+		Dir:     util.ExpandHome(cc.Dir),
+		Env:     cc.Env,
+		Timeout: time.Duration(cc.TimeoutSeconds) * time.Second,
+	}
+	if o.Dir == "" {
+		o.Dir, _ = os.UserHomeDir()
+	}
+	return o
+}
+
+// FetchOptions returns how the feed at link is fetched.
+func (c *Config) FetchOptions(link string) *fd.Options {
+	o := &fd.Options{
+		HTTP:       c.HTTPOptions(link),
+		Gemini:     &fd.GeminiOptions{KnownHosts: KnownHostsPath},
+		Command:    c.CommandOptions(),
+		Filter:     c.Filter(link),
+		Shorthands: c.shorthands(),
+	}
+	if fc, ok := c.Feeds[link]; ok {
+		fc.setRules(o)
+	}
+	return o
+}
+
+// setRules sets the scrape, json and sitemap rules of fc on o.
+func (fc *FeedConfig) setRules(o *fd.Options) {
+	if fc.Scrape != nil {
+		o.Scrape = fc.Scrape.rules()
+	}
+	if fc.JSON != nil {
+		o.JSON = fc.JSON.rules()
+	}
+	if fc.Sitemap != nil {
+		o.Sitemap = fc.Sitemap.rules()
+	}
+}
+
+// Filter returns the filter command of the feed at link, if any.
+func (c *Config) Filter(link string) string {
+	if fc, ok := c.Feeds[link]; ok {
+		return fc.Filter
+	}
+	return ""
+}
+
+// UpdateJob returns a refresh job for f with the settings of f.
+func (c *Config) UpdateJob(f *fd.Feed) *refresh.Job {
+	return refresh.UpdateJob(f, c.FetchOptions(f.FeedLink))
+}
+
+// NewFeedJob returns a refresh job which fetches the feed of s, using color
+// unless s has a color of its own, and the rules of s unless c has rules for
+// the feed.
+func (c *Config) NewFeedJob(s *Subscription, color int) *refresh.Job {
+	if s.Color != 0 {
+		color = s.Color
+	}
+	o := c.FetchOptions(s.URL)
+	if s.Rules != nil && !o.IsMapped() {
+		s.Rules.setRules(o)
+	}
+	return refresh.NewFeedJob(s.URL, s.Title, color, o)
+}
+
+// AddRules saves the scrape, json and sitemap rules which subs were imported
+// with, unless their feeds have rules already. It is called with the
+// subscriptions which have been added, once they are.
+func (c *Config) AddRules(subs []*Subscription) error {
+	isChanged := false
+	for _, s := range subs {
+		if s.Rules == nil {
+			continue
+		}
+		fc, ok := c.Feeds[s.URL]
+		if !ok {
+			fc = &FeedConfig{}
+		}
+		if fc.Scrape != nil || fc.JSON != nil || fc.Sitemap != nil {
+			continue
+		}
+		fc.Scrape, fc.JSON, fc.Sitemap = s.Rules.Scrape, s.Rules.JSON, s.Rules.Sitemap
+		if c.Feeds == nil {
+			c.Feeds = map[string]*FeedConfig{}
+		}
+		c.Feeds[s.URL] = fc
+		isChanged = true
+	}
+	if !isChanged {
+		return nil
+	}
+	return SaveConfig(c)
+}
+
+// validateRules checks that fc has valid rules of at most one kind.
+func (fc *FeedConfig) validateRules() error {
+	mappings := 0
+	for _, isSet := range []bool{fc.Scrape != nil, fc.JSON != nil, fc.Sitemap != nil} {
+		if isSet {
+			mappings++
+		}
+	}
+	if mappings > 1 {
+		return errors.New("may have only one of scrape, json and sitemap")
+	}
+	if fc.Scrape != nil {
+		if err := fc.Scrape.rules().Validate(); err != nil {
+			return errors.Wrap(err, "scrape")
+		}
+	}
+	if fc.JSON != nil {
+		if err := fc.JSON.rules().Validate(); err != nil {
+			return errors.Wrap(err, "json")
+		}
+	}
+	if fc.Sitemap != nil {
+		if err := fc.Sitemap.rules().Validate(); err != nil {
+			return errors.Wrap(err, "sitemap")
+		}
+	}
+	return nil
+}
+
+// validate checks the parts of c which would otherwise only fail when a
+// feed is fetched.
+func (c *Config) validate() error {
+	if c.Feed != nil && c.Feed.Filter != "" {
+		return errors.New(`feed.filter only applies to entries in "feeds"`)
+	}
+	configs := map[string]*HTTPConfig{"http": c.HTTP}
+	for link, fc := range c.Feeds {
+		configs[fmt.Sprintf("feeds[%q].http", link)] = fc.HTTP
+		if err := fc.validateRules(); err != nil {
+			return errors.Wrapf(err, "feeds[%q]", link)
+		}
+	}
+	for i, s := range c.shorthands() {
+		if err := s.Validate(); err != nil {
+			return errors.Wrapf(err, "shorthands[%d]", i)
+		}
+	}
+	for name, hc := range configs {
+		if hc == nil {
+			continue
+		}
+		if hc.Password != "" && !secret.IsRef(hc.Password) {
+			return errors.Errorf("%s.password must refer to a secret as env:NAME, file:PATH or cmd:COMMAND", name)
+		}
+		if hc.BearerToken != "" && !secret.IsRef(hc.BearerToken) {
+			return errors.Errorf("%s.bearerToken must refer to a secret as env:NAME, file:PATH or cmd:COMMAND", name)
+		}
+	}
+	return nil
+}
+
+func (c *Config) RandomColor() int {
+	cc := c.Color
+	return color.GetRandomColor(cc.MaxHue, cc.MinHue, cc.MaxSaturatio, cc.MinSaturatio, cc.MaxLightness, cc.MinLightness)
+}
+
+func (c *Config) ColorRange() []int {
+	cc := c.Color
+	return color.GetColorRange(cc.MaxHue, cc.MinHue, cc.MaxSaturatio, cc.MinSaturatio, cc.MaxLightness, cc.MinLightness)
+}
+
+// Retention returns the history limits for the feed at link.
+func (c *Config) Retention(link string) fd.Retention {
+	days := c.Feed.RetentionDays
+	maxItems := c.Feed.MaxItems
+	if fc, ok := c.Feeds[link]; ok {
+		if fc.RetentionDays != 0 {
+			days = fc.RetentionDays
+		}
+		if fc.MaxItems != 0 {
+			maxItems = fc.MaxItems
+		}
+	}
+
+	r := fd.Retention{}
+	if days > 0 {
+		r.MaxAge = time.Duration(days) * 24 * time.Hour
+	}
+	if maxItems > 0 {
+		r.MaxItems = maxItems
+	}
+	return r
+}
+
 func loadConfig(dataPath string) (*Config, error) {
 	b, err := os.ReadFile(dataPath)
 	if err != nil {
 		return nil, err
 	}
 
+	b, migrated, err := migrateConfig(b, dataPath)
+	if err != nil {
+		return nil, err
+	}
+
 	var config Config
 	if err := json.Unmarshal(b, &config); err != nil {
 		return nil, err
 	}
+	if err := config.validate(); err != nil {
+		return nil, errors.Wrap(err, dataPath)
+	}
+
+	if migrated {
+		if err := saveConfig(&config, dataPath); err != nil {
+			return nil, err
+		}
+	}
 
-	return &config, err
+	return &config, nil
 }
 
 func saveConfig(config *Config, dataPath string) error {
@@ -1,10 +1,18 @@
 package db
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/mmcdole/gofeed"
+
 	"github.com/yitose/rssviewer/internal/color"
+	fd "github.com/yitose/rssviewer/internal/feed"
 )
 
 func print(colorcode int) {
@@ -25,3 +33,111 @@ func TestColor(t *testing.T) {
 	}
 	fmt.Printf("\n%d colors\n", len(colors))
 }
+
+func TestMigrateConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	old := []byte(`{"color":{"enablePaint":true,"maxHue":360}}`)
+	if err := os.WriteFile(path, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Version != ConfigVersion || config.Feed == nil || config.Feed.MaxItems != defaultMaxItems {
+		t.Errorf("config was not migrated: %+v", config)
+	}
+	if config.Color.MaxHue != 360 {
+		t.Errorf("existing settings were lost: %+v", config.Color)
+	}
+	if b, err := os.ReadFile(backupPath(path, 0)); err != nil || string(b) != string(old) {
+		t.Errorf("no backup of the old config: %v", err)
+	}
+}
+
+func TestHTTPOptions(t *testing.T) {
+	c := &Config{
+		HTTP: &HTTPConfig{UserAgent: "global", Headers: map[string]string{"A": "1", "B": "1"}},
+		Feeds: map[string]*FeedConfig{
+			"https://example.com/feed": {HTTP: &HTTPConfig{
+				Headers:     map[string]string{"B": "2"},
+				BearerToken: "env:TOKEN",
+			}},
+		},
+	}
+	o := c.HTTPOptions("https://example.com/feed")
+	if o.UserAgent != "global" || o.Header["A"] != "1" || o.Header["B"] != "2" || o.BearerToken != "env:TOKEN" {
+		t.Errorf("per-feed settings were not merged: %+v", o)
+	}
+	if err := c.validate(); err != nil {
+		t.Error(err)
+	}
+
+	c.HTTP.Password = "plain"
+	if err := c.validate(); err == nil {
+		t.Error("a password in config.json should be refused")
+	}
+
+	c.HTTP.Password = ""
+	c.Feed = &FeedConfig{Filter: "cat"}
+	if err := c.validate(); err == nil {
+		t.Error("a filter for every feed should be refused")
+	}
+}
+
+func TestExportRules(t *testing.T) {
+	d := NewDB()
+	d.Feed = []*fd.Feed{
+		{Feed: &gofeed.Feed{Title: "News", FeedLink: "https://example.com/news"}},
+		{Feed: &gofeed.Feed{Title: "Plain", FeedLink: "https://example.com/feed"}},
+	}
+	c := newConfig()
+	c.Feeds = map[string]*FeedConfig{
+		"https://example.com/news": {Scrape: &ScrapeConfig{Item: "article", Title: "h2"}},
+	}
+	path := filepath.Join(t.TempDir(), "export.opml")
+	if err := d.Export(path, c); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, _, err := ReadSubscriptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("subscriptions = %d, want 2", len(subs))
+	}
+	for _, s := range subs {
+		switch s.URL {
+		case "https://example.com/news":
+			if s.Rules == nil || s.Rules.Scrape == nil || s.Rules.Scrape.Item != "article" || s.Rules.Scrape.Title != "h2" {
+				t.Errorf("the scrape rules were not kept: %+v", s.Rules)
+			}
+		default:
+			if s.Rules != nil {
+				t.Errorf("%s has rules: %+v", s.URL, s.Rules)
+			}
+		}
+	}
+}
+
+func TestNewFeedJobWithImportedRules(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><article><h2>Hello</h2><a href="/hello">more</a></article></body></html>`))
+	}))
+	defer srv.Close()
+
+	c := newConfig()
+	s := &Subscription{URL: srv.URL, Rules: &FeedConfig{Scrape: &ScrapeConfig{Item: "article", Title: "h2", Link: "a"}}}
+	f, _, err := c.NewFeedJob(s, 1).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 1 || f.Items[0].Title != "Hello" {
+		t.Errorf("the imported rules were not used: %+v", f.Items)
+	}
+	if len(c.Feeds) != 0 {
+		t.Errorf("the rules were saved before the feed was added: %v", c.Feeds)
+	}
+}
@@ -1,15 +1,16 @@
 package db
 
 import (
-	"crypto/md5"
-	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
 
 	fd "github.com/yitose/rssviewer/internal/feed"
-	"github.com/yitose/rssviewer/pkg/util"
 )
 
 const (
@@ -19,11 +20,22 @@ const (
 	SavePrefixFeed  = "f_"
 )
 
+var (
+	ErrFeedExists = errors.New("the feed is already subscribed")
+	// ErrFeedRemoved is returned for a feed which another process has
+	// removed since it was loaded. The feed is then removed from d as well.
+	ErrFeedRemoved = errors.New("the feed has been removed")
+)
+
 var (
 	DataPath       = filepath.Join(getDataPath(), "data")
 	ExportListPath = filepath.Join(getDataPath(), "list_export.txt")
 	ImportListPath = filepath.Join(getDataPath(), "list.txt")
+	ExportOPMLPath = filepath.Join(getDataPath(), "export.opml")
+	ImportOPMLPath = filepath.Join(getDataPath(), "import.opml")
 	ConfigPath     = filepath.Join(getDataPath(), "config.json")
+	// KnownHostsPath pins the certificates of Gemini servers.
+	KnownHostsPath = filepath.Join(getDataPath(), "gemini_known_hosts")
 )
 
 type DBInterface interface {
@@ -50,27 +62,208 @@ func getDataPath() string {
 	return filepath.Join(configDir, dataRoot)
 }
 
+// LoadFeeds reads every feed and group from the store, migrating data
+// written by earlier versions first. Records which cannot be decoded are
+// skipped and reported with a *LoadError.
 func (d *FeedDB) LoadFeeds() error {
-	if !util.IsDir(DataPath) {
-		if err := os.MkdirAll(DataPath, 0755); err != nil {
+	loadErr := &LoadError{}
+
+	if err := migrateStore(); err != nil {
+		e, ok := err.(*LoadError)
+		if !ok {
 			return err
 		}
+		loadErr = e
 	}
 
-	for _, file := range util.DirWalk(DataPath) {
-		b, err := os.ReadFile(file)
+	if err := view(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketGroups).ForEach(func(k, v []byte) error {
+			g, err := decodeGroup(v)
+			if err != nil {
+				loadErr.add(string(k), err)
+				return nil
+			}
+			d.Group = append(d.Group, g)
+			return nil
+		}); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketFeeds).ForEach(func(k, v []byte) error {
+			f, err := decodeFeed(v)
+			if err != nil {
+				loadErr.add(string(k), err)
+				return nil
+			}
+			d.Feed = append(d.Feed, f)
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+
+	SortFeed(d.Feed)
+
+	return loadErr.errOrNil()
+}
+
+// InsertFeed saves a newly fetched feed and adds it to d.
+func (d *FeedDB) InsertFeed(f *fd.Feed) error {
+	if d.GetFeed(f.FeedLink) != nil {
+		return ErrFeedExists
+	}
+
+	if err := SaveFeed(f); err != nil {
+		return err
+	}
+
+	d.Feed = append(d.Feed, f)
+	SortFeed(d.Feed)
+
+	return nil
+}
+
+// ApplyUpdate merges a refreshed feed into the stored history of old,
+// records fetch, saves it and puts it in place of old, all within one
+// transaction so that whatever another process saved since old was loaded is
+// kept. newFeed may be old itself when only the time of the fetch has
+// changed. A feed which has been permanently redirected is moved to its new
+// URL, unless that is subscribed already. A feed which is no longer stored is
+// not saved again; see ErrFeedRemoved.
+func (d *FeedDB) ApplyUpdate(old, newFeed *fd.Feed, fetch *fd.Fetch, r fd.Retention) (*fd.Feed, error) {
+	oldLink := old.FeedLink
+	link := oldLink
+	if fetch.MovedTo != "" && fetch.MovedTo != oldLink && d.GetFeed(fetch.MovedTo) == nil {
+		link = fetch.MovedTo
+	}
+	changedGroups := d.movedGroups(oldLink, link)
+
+	if err := update(func(tx *bolt.Tx) error {
+		stored, err := getStoredFeed(tx, oldLink)
 		if err != nil {
 			return err
 		}
-		if strings.HasPrefix(filepath.Base(file), SavePrefixGroup) {
-			d.Group = append(d.Group, fd.DecodeGroup(b))
+		if stored == nil {
+			return ErrFeedRemoved
+		}
+		if newFeed == old {
+			stored.LastFetched = old.LastFetched
+			newFeed = stored
 		} else {
-			d.Feed = append(d.Feed, fd.DecodeFeed(b))
+			newFeed.MergeHistory(stored, r)
+			newFeed.SetColor(stored.Color)
+			newFeed.History = stored.History
+		}
+		newFeed.AddFetch(fetch)
+		newFeed.SetLink(link)
+
+		for g, links := range changedGroups {
+			changed := *g
+			changed.FeedLinks = links
+			b, err := encodeGroup(&changed)
+			if err != nil {
+				return err
+			}
+			if err := put(tx, bucketGroups, g.Title, b); err != nil {
+				return err
+			}
 		}
+		if link != oldLink {
+			if err := del(tx, bucketFeeds, oldLink); err != nil {
+				return err
+			}
+		}
+		return putFeed(tx, newFeed)
+	}); err != nil {
+		newFeed.SetLink(oldLink)
+		if err == ErrFeedRemoved {
+			d.forgetFeed(oldLink)
+		}
+		return old, err
 	}
 
-	SortFeed(d.Feed)
+	for i, feed := range d.Feed {
+		if feed.FeedLink == oldLink {
+			d.Feed[i] = newFeed
+		}
+	}
+	for g, links := range changedGroups {
+		g.FeedLinks = links
+	}
+	return newFeed, nil
+}
+
+// RecordFailure saves the failed fetch of f into its stored record and puts
+// that in place of f. The feed keeps the content of its last successful
+// fetch. A feed which is no longer stored is not saved again; see
+// ErrFeedRemoved.
+func (d *FeedDB) RecordFailure(f *fd.Feed, fetch *fd.Fetch) (*fd.Feed, error) {
+	var stored *fd.Feed
+	if err := update(func(tx *bolt.Tx) error {
+		var err error
+		stored, err = getStoredFeed(tx, f.FeedLink)
+		if err != nil {
+			return err
+		}
+		if stored == nil {
+			return ErrFeedRemoved
+		}
+		stored.AddFetch(fetch)
+		return putFeed(tx, stored)
+	}); err != nil {
+		if err == ErrFeedRemoved {
+			d.forgetFeed(f.FeedLink)
+		}
+		return f, err
+	}
+	d.ReplaceFeed(stored)
+	return stored, nil
+}
+
+// ReplaceFeed puts f in place of the feed with the same link.
+func (d *FeedDB) ReplaceFeed(f *fd.Feed) {
+	for i, feed := range d.Feed {
+		if feed.FeedLink == f.FeedLink {
+			d.Feed[i] = f
+		}
+	}
+}
+
+func (d *FeedDB) GetFeed(link string) *fd.Feed {
+	for _, f := range d.Feed {
+		if f.FeedLink == link {
+			return f
+		}
+	}
+	return nil
+}
+
+func (d *FeedDB) GetGroup(title string) *fd.Group {
+	for _, g := range d.Group {
+		if g.Title == title {
+			return g
+		}
+	}
+	return nil
+}
 
+// AddImportedGroups adds groups read by ReadSubscriptions, leaving out the
+// feeds which could not be added.
+func (d *FeedDB) AddImportedGroups(groups []*fd.Group) error {
+	for _, g := range groups {
+		links := []string{}
+		for _, link := range g.FeedLinks {
+			if d.GetFeed(link) != nil {
+				links = append(links, link)
+			}
+		}
+		if len(links) == 0 {
+			continue
+		}
+		g.FeedLinks = links
+		if err := d.AddOrUpdateGroup(g); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -115,36 +308,88 @@ func (d *FeedDB) AddOrUpdateGroup(g *fd.Group) error {
 	return nil
 }
 
+// movedGroups returns the links of the groups containing from, pointed at to.
+func (d *FeedDB) movedGroups(from, to string) map[*fd.Group][]string {
+	changedGroups := map[*fd.Group][]string{}
+	if from == to {
+		return changedGroups
+	}
+	for _, g := range d.Group {
+		links := append([]string{}, g.FeedLinks...)
+		for i, link := range links {
+			if link == from {
+				links[i] = to
+				changedGroups[g] = links
+			}
+		}
+	}
+	return changedGroups
+}
+
 func SaveGroup(g *fd.Group) error {
 	if g.Title == TodaysFeedTitle {
 		return nil
 	}
-	b, err := fd.EncodeGroup(g)
+	b, err := encodeGroup(g)
 	if err != nil {
 		return err
 	}
-	hash := fmt.Sprintf("%x", md5.Sum([]byte(g.Title)))
-	if err := util.SaveBytes(b, filepath.Join(DataPath, SavePrefixGroup+hash)); err != nil {
-		return err
-	}
-	return nil
+	return update(func(tx *bolt.Tx) error {
+		return put(tx, bucketGroups, g.Title, b)
+	})
 }
 
 func SaveFeed(f *fd.Feed) error {
-	copy := &fd.Feed{}
+	return update(func(tx *bolt.Tx) error {
+		return putFeed(tx, f)
+	})
+}
+
+// ModifyFeed applies fn to f and to the stored record of f in one
+// transaction, so that whatever another process saved since f was loaded is
+// kept. A feed which is no longer stored is not saved again.
+func ModifyFeed(f *fd.Feed, fn func(f *fd.Feed)) error {
+	fn(f)
+	return update(func(tx *bolt.Tx) error {
+		stored, err := getStoredFeed(tx, f.FeedLink)
+		if err != nil || stored == nil {
+			return err
+		}
+		fn(stored)
+		return putFeed(tx, stored)
+	})
+}
 
-	*copy = *f
-	copy.Items = nil
+// MarkItems sets the read state of the items of f whose keys are in keys.
+func MarkItems(f *fd.Feed, keys map[string]bool, read bool) error {
+	return ModifyFeed(f, func(f *fd.Feed) {
+		for _, i := range f.Items {
+			if keys[i.Key()] {
+				i.IsRead = read
+			}
+		}
+	})
+}
 
-	b, err := fd.EncodeFeed(f)
+func putFeed(tx *bolt.Tx, f *fd.Feed) error {
+	b, err := encodeFeed(f)
 	if err != nil {
 		return err
 	}
-	hash := fmt.Sprintf("%x", md5.Sum([]byte(copy.FeedLink)))
-	if err := util.SaveBytes(b, filepath.Join(DataPath, SavePrefixFeed+hash)); err != nil {
-		return err
+	return put(tx, bucketFeeds, f.FeedLink, b)
+}
+
+// getStoredFeed returns the stored record of the feed at link, or nil.
+func getStoredFeed(tx *bolt.Tx, link string) (*fd.Feed, error) {
+	b := tx.Bucket(bucketFeeds)
+	if b == nil {
+		return nil, errMissingBucket
 	}
-	return nil
+	v := b.Get([]byte(link))
+	if v == nil {
+		return nil, nil
+	}
+	return decodeFeed(v)
 }
 
 func SortFeed(feeds []*fd.Feed) {
@@ -160,8 +405,9 @@ func SortGroup(groups []*fd.Group) {
 }
 
 func (d *FeedDB) DeleteGroup(g *fd.Group) error {
-	hash := fmt.Sprintf("%x", md5.Sum([]byte(g.Title)))
-	if err := os.Remove(filepath.Join(DataPath, SavePrefixGroup+hash)); err != nil {
+	if err := update(func(tx *bolt.Tx) error {
+		return del(tx, bucketGroups, g.Title)
+	}); err != nil {
 		return err
 	}
 
@@ -175,31 +421,105 @@ func (d *FeedDB) DeleteGroup(g *fd.Group) error {
 	return nil
 }
 
+// DeleteFeed removes f and its links from every group in one transaction.
+// Groups left without feeds are removed as well.
 func (d *FeedDB) DeleteFeed(f *fd.Feed) error {
-	hash := fmt.Sprintf("%x", md5.Sum([]byte(f.FeedLink)))
-	if err := os.Remove(filepath.Join(DataPath, SavePrefixFeed+hash)); err != nil {
+	changedGroups := map[*fd.Group][]string{}
+	for _, g := range d.Group {
+		links := []string{}
+		for _, link := range g.FeedLinks {
+			if link != f.FeedLink {
+				links = append(links, link)
+			}
+		}
+		if len(links) != len(g.FeedLinks) {
+			changedGroups[g] = links
+		}
+	}
+
+	if err := update(func(tx *bolt.Tx) error {
+		for g, links := range changedGroups {
+			if len(links) == 0 {
+				if err := del(tx, bucketGroups, g.Title); err != nil {
+					return err
+				}
+				continue
+			}
+			changed := *g
+			changed.FeedLinks = links
+			b, err := encodeGroup(&changed)
+			if err != nil {
+				return err
+			}
+			if err := put(tx, bucketGroups, g.Title, b); err != nil {
+				return err
+			}
+		}
+		return del(tx, bucketFeeds, f.FeedLink)
+	}); err != nil {
 		return err
 	}
 
+	d.forgetFeed(f.FeedLink)
+	return nil
+}
+
+// forgetFeed removes the feed at link from d and its groups, without
+// touching the store. Groups left without feeds are removed as well.
+func (d *FeedDB) forgetFeed(link string) {
 	for i, feed := range d.Feed {
-		if f.FeedLink == feed.FeedLink {
+		if feed.FeedLink == link {
 			d.Feed = append(d.Feed[:i], d.Feed[i+1:]...)
 			break
 		}
 	}
 
-	for i, group := range d.Group {
-		for j, link := range group.FeedLinks {
-			if f.FeedLink == link {
-				group.FeedLinks = append(group.FeedLinks[:j], group.FeedLinks[j+1:]...)
+	groups := []*fd.Group{}
+	for _, g := range d.Group {
+		links := []string{}
+		for _, l := range g.FeedLinks {
+			if l != link {
+				links = append(links, l)
+			}
+		}
+		g.FeedLinks = links
+		if len(links) > 0 {
+			groups = append(groups, g)
+		}
+	}
+	d.Group = groups
+}
+
+// GetGroupItems returns the items of every feed in g. For Today's Articles
+// these are the items of all feeds published since midnight.
+func (d *FeedDB) GetGroupItems(g *fd.Group) []*fd.Item {
+	items := []*fd.Item{}
+
+	if g.Title == TodaysFeedTitle {
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		for _, f := range d.Feed {
+			for _, i := range f.Items {
+				if i.PublishedParsed != nil && !today.After(*i.PublishedParsed) {
+					items = append(items, i)
+				}
 			}
-			if len(group.FeedLinks) == 0 {
-				d.Group = append(d.Group[:i], d.Group[i+1:]...)
+		}
+		return items
+	}
+
+	for _, link := range g.FeedLinks {
+		for _, f := range d.Feed {
+			if f.FeedLink == link {
+				items = append(items, f.Items...)
 			}
 		}
 	}
+	return items
+}
 
-	return nil
+func (d *FeedDB) UnreadCount(g *fd.Group) int {
+	return fd.CountUnread(d.GetGroupItems(g))
 }
 
 func (d *FeedDB) GetItemParent(i *fd.Item) *fd.Feed {
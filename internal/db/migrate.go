@@ -0,0 +1,402 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	fd "github.com/yitose/rssviewer/internal/feed"
+	"github.com/yitose/rssviewer/pkg/util"
+)
+
+// The store and config.json both carry a schema version. Data written by an
+// older version is upgraded one step at a time on startup, after a backup of
+// the old file has been taken.
+
+var metaVersionKey = []byte("version")
+
+type storeMigration struct {
+	// migrate upgrades the store to the next version within tx. Records
+	// that cannot be migrated are added to loadErr and left in the backup.
+	migrate func(tx *bolt.Tx, loadErr *LoadError) error
+	// done runs after the migration has been committed.
+	done func() error
+}
+
+var storeMigrations = []storeMigration{
+	// 0: one gob file per feed and group in DataPath.
+	{migrate: migrateDataDir, done: renameDataDir},
+	// 1: the same gob encoding in the store.
+	{migrate: migrateGobRecords},
+	// 2: command feeds keyed by the bare command.
+	{migrate: migrateCommandLinks},
+}
+
+var StoreVersion = len(storeMigrations)
+
+type configMigration func(config map[string]interface{}) error
+
+var configMigrations = []configMigration{
+	// 0: no "version" and no "feed" entry.
+	migrateConfigFeed,
+	// 1: no refresh interval.
+	migrateConfigRefresh,
+	// 2: no "autoRefresh".
+	migrateConfigAutoRefresh,
+	// 3: no "refresh" limits.
+	migrateConfigRefreshLimits,
+	// 4: no "health" thresholds.
+	migrateConfigHealth,
+	// 5: commands in "feeds" without fd.CommandPrefix, and no "command".
+	migrateConfigCommands,
+}
+
+var ConfigVersion = len(configMigrations)
+
+// migrateStore brings the store to StoreVersion. Records which could not be
+// migrated are reported with a *LoadError once everything else is done.
+func migrateStore() error {
+	version, err := getStoreVersion()
+	if err != nil {
+		return err
+	}
+	if version > StoreVersion {
+		return errors.Errorf("%s has version %d, which is newer than this rssviewer supports (%d)", StorePath, version, StoreVersion)
+	}
+	if version == StoreVersion {
+		return nil
+	}
+
+	if util.IsFile(StorePath) {
+		if err := util.CopyFile(StorePath, backupPath(StorePath, version)); err != nil {
+			return errors.Wrap(err, "backing up the store")
+		}
+	}
+
+	loadErr := &LoadError{}
+	for ; version < StoreVersion; version++ {
+		m := storeMigrations[version]
+		if err := update(func(tx *bolt.Tx) error {
+			if err := m.migrate(tx, loadErr); err != nil {
+				return err
+			}
+			return tx.Bucket(bucketMeta).Put(metaVersionKey, []byte(strconv.Itoa(version+1)))
+		}); err != nil {
+			return errors.Wrapf(err, "migrating the store to version %d", version+1)
+		}
+		if m.done != nil {
+			if err := m.done(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return loadErr.errOrNil()
+}
+
+func getStoreVersion() (int, error) {
+	if !util.IsFile(StorePath) {
+		if util.IsDir(DataPath) {
+			return 0, nil
+		}
+		// Nothing to migrate; a new store starts at the current version.
+		return StoreVersion, update(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucketMeta).Put(metaVersionKey, []byte(strconv.Itoa(StoreVersion)))
+		})
+	}
+
+	version := 1
+	err := view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketMeta).Get(metaVersionKey); v != nil {
+			n, err := strconv.Atoi(string(v))
+			if err != nil {
+				return errors.Wrap(err, "reading the store version")
+			}
+			version = n
+		}
+		return nil
+	})
+	return version, err
+}
+
+func backupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// migrateDataDir copies the gob files written by earlier versions into the
+// store. The directory itself is renamed afterwards and serves as backup.
+func migrateDataDir(tx *bolt.Tx, loadErr *LoadError) error {
+	if !util.IsDir(DataPath) {
+		return nil
+	}
+
+	for _, file := range util.DirWalk(DataPath) {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(filepath.Base(file), SavePrefixGroup) {
+			g, err := fd.DecodeGroup(b)
+			if err != nil {
+				loadErr.add(file, err)
+				continue
+			}
+			if err := put(tx, bucketGroups, g.Title, b); err != nil {
+				return err
+			}
+		} else {
+			f, err := fd.DecodeFeed(b)
+			if err != nil {
+				loadErr.add(file, err)
+				continue
+			}
+			if err := put(tx, bucketFeeds, f.FeedLink, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func renameDataDir() error {
+	if !util.IsDir(DataPath) {
+		return nil
+	}
+	return os.Rename(DataPath, MigratedDataPath)
+}
+
+// migrateGobRecords re-encodes gob-encoded feeds and groups as records.
+func migrateGobRecords(tx *bolt.Tx, loadErr *LoadError) error {
+	// A nil value deletes the record.
+	type change struct {
+		bucket []byte
+		key    string
+		value  []byte
+	}
+	changes := []change{}
+
+	if err := tx.Bucket(bucketFeeds).ForEach(func(k, v []byte) error {
+		f, err := fd.DecodeFeed(v)
+		if err == nil {
+			v, err = encodeFeed(f)
+		}
+		if err != nil {
+			loadErr.add(string(k), err)
+			v = nil
+		}
+		changes = append(changes, change{bucketFeeds, string(k), v})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(bucketGroups).ForEach(func(k, v []byte) error {
+		g, err := fd.DecodeGroup(v)
+		if err == nil {
+			v, err = encodeGroup(g)
+		}
+		if err != nil {
+			loadErr.add(string(k), err)
+			v = nil
+		}
+		changes = append(changes, change{bucketGroups, string(k), v})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Buckets must not be modified while iterating over them.
+	for _, c := range changes {
+		if c.value == nil {
+			if err := del(tx, c.bucket, c.key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := put(tx, c.bucket, c.key, c.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateConfig brings the decoded contents of config.json to ConfigVersion,
+// reporting whether anything was changed.
+func migrateConfig(b []byte, path string) ([]byte, bool, error) {
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, false, err
+	}
+
+	version := 0
+	if v, ok := config["version"].(float64); ok {
+		version = int(v)
+	}
+	if version > ConfigVersion {
+		return nil, false, errors.Errorf("%s has version %d, which is newer than this rssviewer supports (%d)", path, version, ConfigVersion)
+	}
+	if version == ConfigVersion {
+		return b, false, nil
+	}
+
+	if err := util.SaveBytes(b, backupPath(path, version)); err != nil {
+		return nil, false, errors.Wrap(err, "backing up the config")
+	}
+
+	for ; version < ConfigVersion; version++ {
+		if err := configMigrations[version](config); err != nil {
+			return nil, false, errors.Wrapf(err, "migrating the config to version %d", version+1)
+		}
+	}
+	config["version"] = ConfigVersion
+
+	b, err := json.Marshal(config)
+	return b, true, err
+}
+
+func migrateConfigFeed(config map[string]interface{}) error {
+	if _, ok := config["feed"]; !ok {
+		config["feed"] = map[string]interface{}{"maxItems": defaultMaxItems}
+	}
+	return nil
+}
+
+func migrateConfigRefresh(config map[string]interface{}) error {
+	feed, ok := config["feed"].(map[string]interface{})
+	if !ok {
+		return errors.New(`"feed" is not an object`)
+	}
+	if _, ok := feed["refreshMinutes"]; !ok {
+		feed["refreshMinutes"] = defaultRefreshMins
+	}
+	return nil
+}
+
+func migrateConfigAutoRefresh(config map[string]interface{}) error {
+	if _, ok := config["autoRefresh"]; !ok {
+		config["autoRefresh"] = defaultAutoRefresh
+	}
+	return nil
+}
+
+func migrateConfigRefreshLimits(config map[string]interface{}) error {
+	if _, ok := config["refresh"]; !ok {
+		config["refresh"] = map[string]interface{}{
+			"concurrency":      defaultConcurrency,
+			"hostDelaySeconds": defaultHostDelaySecs,
+			"timeoutSeconds":   defaultTimeoutSecs,
+		}
+	}
+	return nil
+}
+
+func migrateConfigHealth(config map[string]interface{}) error {
+	if _, ok := config["health"]; !ok {
+		config["health"] = map[string]interface{}{
+			"maxFailures":   defaultMaxFailures,
+			"maxSilentDays": defaultMaxSilentDays,
+		}
+	}
+	return nil
+}
+
+// migrateConfigCommands marks the per-feed settings of command feeds with
+// fd.CommandPrefix, as migrateCommandLinks does in the store.
+func migrateConfigCommands(config map[string]interface{}) error {
+	if _, ok := config["command"]; !ok {
+		config["command"] = map[string]interface{}{"timeoutSeconds": defaultCommandSecs}
+	}
+	feeds, ok := config["feeds"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for link, fc := range feeds {
+		if to := commandLink(link); to != link {
+			delete(feeds, link)
+			feeds[to] = fc
+		}
+	}
+	return nil
+}
+
+// migrateCommandLinks prefixes the links of command feeds, which used to be
+// any link that was not a URL, with fd.CommandPrefix.
+func migrateCommandLinks(tx *bolt.Tx, loadErr *LoadError) error {
+	type change struct {
+		bucket []byte
+		key    string
+		newKey string
+		value  []byte
+	}
+	changes := []change{}
+
+	if err := tx.Bucket(bucketFeeds).ForEach(func(k, v []byte) error {
+		link := commandLink(string(k))
+		if link == string(k) {
+			return nil
+		}
+		f, err := decodeFeed(v)
+		if err == nil {
+			f.SetLink(link)
+			v, err = encodeFeed(f)
+		}
+		if err != nil {
+			loadErr.add(string(k), err)
+			return nil
+		}
+		changes = append(changes, change{bucketFeeds, string(k), link, v})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(bucketGroups).ForEach(func(k, v []byte) error {
+		g, err := decodeGroup(v)
+		if err != nil {
+			loadErr.add(string(k), err)
+			return nil
+		}
+		isChanged := false
+		for i, link := range g.FeedLinks {
+			if to := commandLink(link); to != link {
+				g.FeedLinks[i] = to
+				isChanged = true
+			}
+		}
+		if !isChanged {
+			return nil
+		}
+		if v, err = encodeGroup(g); err != nil {
+			return err
+		}
+		changes = append(changes, change{bucketGroups, string(k), string(k), v})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		if err := del(tx, c.bucket, c.key); err != nil {
+			return err
+		}
+		if err := put(tx, c.bucket, c.newKey, c.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandLink returns the link of a feed stored as link before command links
+// had fd.CommandPrefix.
+func commandLink(link string) string {
+	if fd.IsURL(link) || fd.IsCommand(link) {
+		return link
+	}
+	return fd.CommandLink(link)
+}
@@ -0,0 +1,254 @@
+package db
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	fd "github.com/yitose/rssviewer/internal/feed"
+	"github.com/yitose/rssviewer/internal/opml"
+	"github.com/yitose/rssviewer/pkg/util"
+)
+
+// Subscription is a feed to be added by an import. Title and Color are
+// optional, and a Color of 0 means that a random color should be used.
+// Rules holds the scrape, json or sitemap rules of a feed exported by
+// rssviewer; see Config.AddRules.
+type Subscription struct {
+	URL   string
+	Title string
+	Color int
+	Rules *FeedConfig
+}
+
+func IsOPMLPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".opml", ".xml":
+		return true
+	}
+	return false
+}
+
+// ReadSubscriptions reads an OPML file, or a list with one URL per line.
+// Command feeds in either are marked with fd.CommandPrefix; see
+// CommandSubscriptions.
+func ReadSubscriptions(path string) ([]*Subscription, []*fd.Group, error) {
+	if IsOPMLPath(path) {
+		return readOPML(path)
+	}
+
+	_, lines, err := util.GetLines(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	subs := []*Subscription{}
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" {
+			subs = append(subs, &Subscription{URL: l})
+		}
+	}
+	return subs, []*fd.Group{}, nil
+}
+
+// ExpandSubscription returns the subscriptions which s stands for, and a
+// group of them if there are several. A mailbox which does not select a
+// mailing list or sender stands for a feed per list and sender in it; any
+// other subscription for itself.
+func ExpandSubscription(s *Subscription) ([]*Subscription, *fd.Group, error) {
+	if !fd.IsMail(s.URL) {
+		return []*Subscription{s}, nil, nil
+	}
+	candidates, err := fd.MailFeeds(s.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(candidates) == 0 {
+		return []*Subscription{s}, nil, nil
+	}
+
+	subs := []*Subscription{}
+	title := s.Title
+	if title == "" {
+		title = filepath.Base(strings.TrimPrefix(s.URL, fd.MailPrefix))
+	}
+	g := &fd.Group{Title: title}
+	for _, c := range candidates {
+		subs = append(subs, &Subscription{URL: c.URL, Title: c.Title, Color: s.Color})
+		g.FeedLinks = append(g.FeedLinks, c.URL)
+	}
+	if len(subs) == 1 {
+		return subs, nil, nil
+	}
+	return subs, g, nil
+}
+
+// CommandSubscriptions returns the subscriptions in subs which are commands,
+// to be confirmed before they are run.
+func CommandSubscriptions(subs []*Subscription) []*Subscription {
+	cmds := []*Subscription{}
+	for _, s := range subs {
+		if fd.IsCommand(s.URL) {
+			cmds = append(cmds, s)
+		}
+	}
+	return cmds
+}
+
+// WithoutCommands returns subs without the command feeds.
+func WithoutCommands(subs []*Subscription) []*Subscription {
+	kept := []*Subscription{}
+	for _, s := range subs {
+		if !fd.IsCommand(s.URL) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+func readOPML(path string) ([]*Subscription, []*fd.Group, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	o, err := opml.Parse(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subs := []*Subscription{}
+	isAdded := map[string]bool{}
+	groups := []*fd.Group{}
+
+	// Nested categories are flattened, and each feed joins the group of the
+	// category closest to it.
+	var walk func(outlines []*opml.Outline, group *fd.Group)
+	walk = func(outlines []*opml.Outline, group *fd.Group) {
+		for _, ol := range outlines {
+			if !ol.IsFeed() {
+				g := &fd.Group{Title: ol.Name()}
+				walk(ol.Outlines, g)
+				if len(g.FeedLinks) > 0 {
+					groups = append(groups, g)
+				}
+				continue
+			}
+
+			url := ol.XMLURL
+			if ol.Command != "" {
+				url = fd.CommandLink(ol.Command)
+			}
+			if !isAdded[url] {
+				subs = append(subs, &Subscription{URL: url, Title: ol.Name(), Color: ol.Color, Rules: outlineRules(ol)})
+				isAdded[url] = true
+			}
+			if group != nil {
+				group.FeedLinks = util.RemoveDuplicate(append(group.FeedLinks, url))
+			}
+		}
+	}
+	walk(o.Body.Outlines, nil)
+
+	return subs, groups, nil
+}
+
+// outlineRules returns the rules of ol, or nil if it has none. Rules which
+// cannot be decoded or are invalid are dropped.
+func outlineRules(ol *opml.Outline) *FeedConfig {
+	fc := &FeedConfig{}
+	isSet := false
+	for _, r := range []struct {
+		value string
+		dst   interface{}
+	}{
+		{ol.Scrape, &fc.Scrape},
+		{ol.JSON, &fc.JSON},
+		{ol.Sitemap, &fc.Sitemap},
+	} {
+		if r.value != "" && json.Unmarshal([]byte(r.value), r.dst) == nil {
+			isSet = true
+		}
+	}
+	if !isSet || fc.validateRules() != nil {
+		return nil
+	}
+	return fc
+}
+
+// Export writes the feeds to path as OPML, keeping group membership and the
+// rules of c for each feed, or as a list with one URL per line.
+func (d *FeedDB) Export(path string, c *Config) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !IsOPMLPath(path) {
+		for _, f := range d.Feed {
+			if _, err := file.WriteString(f.FeedLink + "\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	o := opml.New("rssviewer subscriptions")
+	o.Head.DateCreated = time.Now().Format(time.RFC1123Z)
+
+	isGrouped := map[string]bool{}
+	for _, g := range d.Group {
+		outline := &opml.Outline{Text: g.Title, Title: g.Title}
+		for _, link := range g.FeedLinks {
+			for _, f := range d.Feed {
+				if f.FeedLink == link {
+					outline.Outlines = append(outline.Outlines, newOutline(f, c.Feeds[link]))
+					isGrouped[link] = true
+				}
+			}
+		}
+		o.Body.Outlines = append(o.Body.Outlines, outline)
+	}
+	for _, f := range d.Feed {
+		if !isGrouped[f.FeedLink] {
+			o.Body.Outlines = append(o.Body.Outlines, newOutline(f, c.Feeds[f.FeedLink]))
+		}
+	}
+
+	return o.Write(file)
+}
+
+func newOutline(f *fd.Feed, fc *FeedConfig) *opml.Outline {
+	ol := &opml.Outline{
+		Text:    f.Title,
+		Title:   f.Title,
+		Type:    "rss",
+		HTMLURL: f.Link,
+		Color:   f.Color,
+	}
+	if fd.IsCommand(f.FeedLink) {
+		ol.Command = fd.CommandOf(f.FeedLink)
+	} else {
+		ol.XMLURL = f.FeedLink
+	}
+	if fc != nil && fc.Scrape != nil {
+		ol.Scrape = encodeRules(fc.Scrape)
+	}
+	if fc != nil && fc.JSON != nil {
+		ol.JSON = encodeRules(fc.JSON)
+	}
+	if fc != nil && fc.Sitemap != nil {
+		ol.Sitemap = encodeRules(fc.Sitemap)
+	}
+	return ol
+}
+
+// encodeRules returns rules as JSON. The rules are plain structs, which
+// always encode.
+func encodeRules(rules interface{}) string {
+	b, _ := json.Marshal(rules)
+	return string(b)
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	fd "github.com/yitose/rssviewer/internal/feed"
+)
+
+// The records below are the on-disk format of feeds and groups. They are
+// kept apart from fd.Feed, which embeds gofeed types, so that changes to
+// those types never change what is stored. Fields may be added with
+// omitempty; any other change to a record needs a new store migration.
+
+type feedRecord struct {
+	Title        string         `json:"title"`
+	Description  string         `json:"description,omitempty"`
+	Link         string         `json:"link,omitempty"`
+	FeedLink     string         `json:"feedLink"`
+	FeedType     string         `json:"feedType,omitempty"`
+	Language     string         `json:"language,omitempty"`
+	Updated      *time.Time     `json:"updated,omitempty"`
+	Published    *time.Time     `json:"published,omitempty"`
+	Color        int            `json:"color"`
+	ETag         string         `json:"etag,omitempty"`
+	LastModified string         `json:"lastModified,omitempty"`
+	LastFetched  time.Time      `json:"lastFetched,omitempty"`
+	TTL          int            `json:"ttl,omitempty"`
+	SkipHours    []int          `json:"skipHours,omitempty"`
+	SkipDays     []string       `json:"skipDays,omitempty"`
+	LastError    string         `json:"lastError,omitempty"`
+	LastErrorAt  time.Time      `json:"lastErrorAt,omitempty"`
+	FailureCount int            `json:"failureCount,omitempty"`
+	History      []*fetchRecord `json:"history,omitempty"`
+	Disabled     bool           `json:"disabled,omitempty"`
+	Items        []*itemRecord  `json:"items"`
+}
+
+type itemRecord struct {
+	GUID        string     `json:"guid,omitempty"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	Content     string     `json:"content,omitempty"`
+	Link        string     `json:"link,omitempty"`
+	Updated     *time.Time `json:"updated,omitempty"`
+	Published   *time.Time `json:"published,omitempty"`
+	AuthorName  string     `json:"authorName,omitempty"`
+	AuthorEmail string     `json:"authorEmail,omitempty"`
+	Categories  []string   `json:"categories,omitempty"`
+	IsRead      bool       `json:"isRead,omitempty"`
+}
+
+type fetchRecord struct {
+	At        time.Time `json:"at"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMs int64     `json:"latencyMs"`
+	Size      int       `json:"size,omitempty"`
+	Items     int       `json:"items,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	MovedTo   string    `json:"movedTo,omitempty"`
+}
+
+type groupRecord struct {
+	Title     string   `json:"title"`
+	FeedLinks []string `json:"feedLinks"`
+}
+
+func encodeFeed(f *fd.Feed) ([]byte, error) {
+	r := &feedRecord{
+		Title:        f.Title,
+		Description:  f.Description,
+		Link:         f.Link,
+		FeedLink:     f.FeedLink,
+		FeedType:     f.FeedType,
+		Language:     f.Language,
+		Updated:      f.UpdatedParsed,
+		Published:    f.PublishedParsed,
+		Color:        f.Color,
+		ETag:         f.ETag,
+		LastModified: f.LastModified,
+		LastFetched:  f.LastFetched,
+		TTL:          f.TTL,
+		SkipHours:    f.SkipHours,
+		SkipDays:     f.SkipDays,
+		LastError:    f.LastError,
+		LastErrorAt:  f.LastErrorAt,
+		FailureCount: f.FailureCount,
+		Disabled:     f.Disabled,
+		Items:        []*itemRecord{},
+	}
+	for _, fetch := range f.History {
+		r.History = append(r.History, &fetchRecord{
+			At:        fetch.At,
+			Status:    fetch.Status,
+			LatencyMs: fetch.Latency.Milliseconds(),
+			Size:      fetch.Size,
+			Items:     fetch.Items,
+			Error:     fetch.Error,
+			MovedTo:   fetch.MovedTo,
+		})
+	}
+	for _, i := range f.Items {
+		ir := &itemRecord{
+			GUID:        i.GUID,
+			Title:       i.Title,
+			Description: i.Description,
+			Content:     i.Content,
+			Link:        i.Link,
+			Updated:     i.UpdatedParsed,
+			Published:   i.PublishedParsed,
+			Categories:  i.Categories,
+			IsRead:      i.IsRead,
+		}
+		if i.Author != nil {
+			ir.AuthorName = i.Author.Name
+			ir.AuthorEmail = i.Author.Email
+		}
+		r.Items = append(r.Items, ir)
+	}
+	return json.Marshal(r)
+}
+
+func decodeFeed(b []byte) (*fd.Feed, error) {
+	var r feedRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+
+	f := &fd.Feed{
+		Feed: &gofeed.Feed{
+			Title:           r.Title,
+			Description:     r.Description,
+			Link:            r.Link,
+			FeedLink:        r.FeedLink,
+			FeedType:        r.FeedType,
+			Language:        r.Language,
+			UpdatedParsed:   r.Updated,
+			PublishedParsed: r.Published,
+		},
+		Color:        r.Color,
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		LastFetched:  r.LastFetched,
+		TTL:          r.TTL,
+		SkipHours:    r.SkipHours,
+		SkipDays:     r.SkipDays,
+		LastError:    r.LastError,
+		LastErrorAt:  r.LastErrorAt,
+		FailureCount: r.FailureCount,
+		Disabled:     r.Disabled,
+		Items:        []*fd.Item{},
+	}
+	for _, fr := range r.History {
+		f.History = append(f.History, &fd.Fetch{
+			At:      fr.At,
+			Status:  fr.Status,
+			Latency: time.Duration(fr.LatencyMs) * time.Millisecond,
+			Size:    fr.Size,
+			Items:   fr.Items,
+			Error:   fr.Error,
+			MovedTo: fr.MovedTo,
+		})
+	}
+	for _, ir := range r.Items {
+		i := &fd.Item{
+			Item: &gofeed.Item{
+				GUID:            ir.GUID,
+				Title:           ir.Title,
+				Description:     ir.Description,
+				Content:         ir.Content,
+				Link:            ir.Link,
+				UpdatedParsed:   ir.Updated,
+				PublishedParsed: ir.Published,
+				Categories:      ir.Categories,
+			},
+			Belong: f.FeedLink,
+			Color:  f.Color,
+			IsRead: ir.IsRead,
+		}
+		if ir.AuthorName != "" || ir.AuthorEmail != "" {
+			i.Author = &gofeed.Person{Name: ir.AuthorName, Email: ir.AuthorEmail}
+			i.Authors = []*gofeed.Person{i.Author}
+		}
+		f.Items = append(f.Items, i)
+	}
+	return f, nil
+}
+
+func encodeGroup(g *fd.Group) ([]byte, error) {
+	return json.Marshal(&groupRecord{
+		Title:     g.Title,
+		FeedLinks: g.FeedLinks,
+	})
+}
+
+func decodeGroup(b []byte) (*fd.Group, error) {
+	var r groupRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return &fd.Group{Title: r.Title, FeedLinks: r.FeedLinks}, nil
+}
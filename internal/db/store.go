@@ -0,0 +1,166 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const storeLockTimeout = 10 * time.Second
+
+var (
+	StorePath        = filepath.Join(getDataPath(), "rssviewer.db")
+	MigratedDataPath = DataPath + ".migrated"
+	bucketFeeds      = []byte("feeds")
+	bucketGroups     = []byte("groups")
+	bucketMeta       = []byte("meta")
+)
+
+var (
+	ErrStoreLocked   = errors.New("the store is used by another rssviewer process")
+	errMissingBucket = errors.New("missing bucket")
+)
+
+// LoadError reports stored records which could not be decoded. Every other
+// record is loaded regardless.
+type LoadError struct {
+	Keys []string
+	Errs []error
+}
+
+func (e *LoadError) Error() string {
+	msgs := []string{}
+	for i, key := range e.Keys {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", key, e.Errs[i]))
+	}
+	return fmt.Sprintf("%d records could not be loaded: %s", len(e.Keys), strings.Join(msgs, ", "))
+}
+
+func (e *LoadError) add(key string, err error) {
+	e.Keys = append(e.Keys, key)
+	e.Errs = append(e.Errs, err)
+}
+
+func (e *LoadError) errOrNil() error {
+	if len(e.Keys) == 0 {
+		return nil
+	}
+	return e
+}
+
+// storeHoldTime bounds how long a process keeps the store open after opening
+// it. Transactions within that time share the handle, and other rssviewer
+// processes, like the daemon next to the TUI, get the file lock afterwards.
+const storeHoldTime = time.Second
+
+// The store is opened on the first transaction and closed again storeHoldTime
+// later, so that the TUI and command line invocations can share it. Within a
+// process, storeMu keeps goroutines from waiting on each other's file lock.
+var (
+	storeMu sync.Mutex
+	store   *bolt.DB
+)
+
+func openStore() (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(StorePath), 0755); err != nil {
+		return nil, err
+	}
+	s, err := bolt.Open(StorePath, 0600, &bolt.Options{Timeout: storeLockTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrStoreLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketFeeds, bucketGroups, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// withStore calls fn with the open store, opening it if needed. storeMu must
+// be held.
+func withStore(fn func(s *bolt.DB) error) error {
+	if store != nil && store.Path() != StorePath {
+		closeStore()
+	}
+	if store == nil {
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		store = s
+		time.AfterFunc(storeHoldTime, func() {
+			storeMu.Lock()
+			defer storeMu.Unlock()
+			if store == s {
+				closeStore()
+			}
+		})
+	}
+	return fn(store)
+}
+
+func closeStore() error {
+	if store == nil {
+		return nil
+	}
+	err := store.Close()
+	store = nil
+	return err
+}
+
+// CloseStore releases the store before the process exits.
+func CloseStore() error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	return closeStore()
+}
+
+func update(fn func(tx *bolt.Tx) error) error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	return withStore(func(s *bolt.DB) error {
+		return s.Update(fn)
+	})
+}
+
+func view(fn func(tx *bolt.Tx) error) error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	return withStore(func(s *bolt.DB) error {
+		return s.View(fn)
+	})
+}
+
+func put(tx *bolt.Tx, bucket []byte, key string, value []byte) error {
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return errMissingBucket
+	}
+	return b.Put([]byte(key), value)
+}
+
+func del(tx *bolt.Tx, bucket []byte, key string) error {
+	b := tx.Bucket(bucket)
+	if b == nil {
+		return errMissingBucket
+	}
+	return b.Delete([]byte(key))
+}
@@ -0,0 +1,336 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	bolt "go.etcd.io/bbolt"
+
+	fd "github.com/yitose/rssviewer/internal/feed"
+)
+
+func useTempStore(t *testing.T) string {
+	dir := t.TempDir()
+	dataPath, storePath, migratedDataPath := DataPath, StorePath, MigratedDataPath
+	DataPath = filepath.Join(dir, "data")
+	StorePath = filepath.Join(dir, "rssviewer.db")
+	MigratedDataPath = DataPath + ".migrated"
+	t.Cleanup(func() {
+		CloseStore()
+		DataPath, StorePath, MigratedDataPath = dataPath, storePath, migratedDataPath
+	})
+	return dir
+}
+
+func TestMigrateDataDir(t *testing.T) {
+	useTempStore(t)
+	if err := os.MkdirAll(DataPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	feed := &fd.Feed{Feed: &gofeed.Feed{Title: "Feed", FeedLink: "https://example.com/feed"}}
+	b, err := fd.EncodeFeed(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(DataPath, SavePrefixFeed+"1"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(DataPath, SavePrefixFeed+"2"), b[:len(b)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err = fd.EncodeGroup(&fd.Group{Title: "Group", FeedLinks: []string{feed.FeedLink}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(DataPath, SavePrefixGroup+"1"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDB()
+	err = d.LoadFeeds()
+	loadErr, ok := err.(*LoadError)
+	if !ok || len(loadErr.Keys) != 1 {
+		t.Fatalf("expected one undecodable record, got %v", err)
+	}
+	if len(d.Feed) != 1 || d.Feed[0].Title != "Feed" || len(d.Group) != 1 {
+		t.Fatalf("unexpected contents: %d feeds, %d groups", len(d.Feed), len(d.Group))
+	}
+	if _, err := os.Stat(DataPath); !os.IsNotExist(err) {
+		t.Errorf("data directory should have been renamed")
+	}
+
+	d = NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Feed) != 1 {
+		t.Errorf("feeds = %d after reload, want 1", len(d.Feed))
+	}
+}
+
+func TestDeleteFeedUpdatesGroups(t *testing.T) {
+	useTempStore(t)
+
+	d := NewDB()
+	feed := &fd.Feed{Feed: &gofeed.Feed{FeedLink: "a"}}
+	d.Feed = append(d.Feed, feed)
+	if err := SaveFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddOrUpdateGroup(&fd.Group{Title: "g", FeedLinks: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DeleteFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+
+	d = NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Feed) != 0 || len(d.Group) != 0 {
+		t.Errorf("unexpected contents: %d feeds, %d groups", len(d.Feed), len(d.Group))
+	}
+}
+
+func TestRecordFailureKeepsContent(t *testing.T) {
+	useTempStore(t)
+
+	d := NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	feed := &fd.Feed{
+		Feed:  &gofeed.Feed{Title: "A", FeedLink: "a"},
+		Items: []*fd.Item{{Item: &gofeed.Item{Title: "1"}}},
+	}
+	if err := d.InsertFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.RecordFailure(feed, &fd.Fetch{At: time.Now(), Status: 500, Error: "boom"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d = NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	f := d.GetFeed("a")
+	if f == nil || f.Title != "A" || len(f.Items) != 1 {
+		t.Fatalf("the content was not kept: %+v", f)
+	}
+	if !f.IsStale() || f.LastError != "boom" || f.FailureCount != 2 || f.LastErrorAt.IsZero() {
+		t.Errorf("the failure was not stored: %q %d %v", f.LastError, f.FailureCount, f.LastErrorAt)
+	}
+	if len(f.History) != 2 || f.History[0].Status != 500 {
+		t.Errorf("the fetch history was not stored: %+v", f.History)
+	}
+
+	f, err := d.ApplyUpdate(f, f, &fd.Fetch{At: time.Now(), Status: 200}, fd.Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.IsStale() || len(f.History) != 3 {
+		t.Errorf("a successful fetch should clear the failure")
+	}
+}
+
+func TestApplyUpdateMovesFeed(t *testing.T) {
+	useTempStore(t)
+
+	d := NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	feed := &fd.Feed{Feed: &gofeed.Feed{Title: "A", FeedLink: "old"}}
+	if err := d.InsertFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddOrUpdateGroup(&fd.Group{Title: "g", FeedLinks: []string{"old"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.ApplyUpdate(feed, feed, &fd.Fetch{At: time.Now(), Status: 200, MovedTo: "new"}, fd.Retention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	d = NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Feed) != 1 || d.GetFeed("new") == nil {
+		t.Errorf("the feed was not moved: %d feeds", len(d.Feed))
+	}
+	if g := d.GetGroup("g"); g == nil || len(g.FeedLinks) != 1 || g.FeedLinks[0] != "new" {
+		t.Errorf("the group was not updated: %+v", g)
+	}
+}
+
+func TestMarkItemsKeepsOtherUpdates(t *testing.T) {
+	useTempStore(t)
+
+	newItem := func(guid string) *fd.Item {
+		now := time.Now()
+		return &fd.Item{Item: &gofeed.Item{GUID: guid, Title: guid, PublishedParsed: &now}, Belong: "a"}
+	}
+	// The TUI and the daemon each load the feed.
+	tui, daemon := NewDB(), NewDB()
+	if err := tui.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	feed := &fd.Feed{Feed: &gofeed.Feed{Title: "A", FeedLink: "a"}, Items: []*fd.Item{newItem("1")}}
+	if err := tui.InsertFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := &fd.Feed{Feed: &gofeed.Feed{Title: "A", FeedLink: "a"}, Items: []*fd.Item{newItem("2"), newItem("1")}, ETag: "v2"}
+	if _, err := daemon.ApplyUpdate(daemon.GetFeed("a"), refreshed, &fd.Fetch{At: time.Now(), Status: 200}, fd.Retention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := tui.GetFeed("a")
+	if err := MarkItems(stale, map[string]bool{"1": true}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tui.ApplyUpdate(stale, stale, &fd.Fetch{At: time.Now(), Status: 304}, fd.Retention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	f := d.GetFeed("a")
+	if len(f.Items) != 2 || f.ETag != "v2" || len(f.History) != 2 {
+		t.Fatalf("the update of the daemon was lost: %d items, etag %q, %d fetches", len(f.Items), f.ETag, len(f.History))
+	}
+	for _, i := range f.Items {
+		if i.IsRead != (i.GUID == "1") {
+			t.Errorf("item %s: read = %v", i.GUID, i.IsRead)
+		}
+	}
+	if tui.GetFeed("a").ETag != "v2" {
+		t.Errorf("the TUI was not given the stored feed")
+	}
+}
+
+func TestMigrateGobStore(t *testing.T) {
+	useTempStore(t)
+
+	feed := &fd.Feed{Feed: &gofeed.Feed{Title: "Feed", FeedLink: "a"}, Color: 10}
+	b, err := fd.EncodeFeed(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A version 1 store has no version entry.
+	if err := update(func(tx *bolt.Tx) error {
+		return put(tx, bucketFeeds, feed.FeedLink, b)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Feed) != 1 || d.Feed[0].Title != "Feed" || d.Feed[0].Color != 10 {
+		t.Fatalf("unexpected feeds after migration: %v", d.Feed)
+	}
+	if _, err := os.Stat(backupPath(StorePath, 1)); err != nil {
+		t.Errorf("no backup of the version 1 store: %v", err)
+	}
+	version, err := getStoreVersion()
+	if err != nil || version != StoreVersion {
+		t.Errorf("version = %d, %v, want %d", version, err, StoreVersion)
+	}
+}
+
+func TestMigrateCommandLinks(t *testing.T) {
+	useTempStore(t)
+
+	feed := &fd.Feed{Feed: &gofeed.Feed{Title: "Local", FeedLink: "cat feed.xml"}}
+	fb, err := encodeFeed(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gb, err := encodeGroup(&fd.Group{Title: "Group", FeedLinks: []string{"cat feed.xml", "https://example.com/feed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := update(func(tx *bolt.Tx) error {
+		if err := put(tx, bucketMeta, string(metaVersionKey), []byte("2")); err != nil {
+			return err
+		}
+		if err := put(tx, bucketFeeds, feed.FeedLink, fb); err != nil {
+			return err
+		}
+		return put(tx, bucketGroups, "Group", gb)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Feed) != 1 || d.Feed[0].FeedLink != "exec:cat feed.xml" {
+		t.Fatalf("the command feed was not migrated: %v", d.Feed[0].FeedLink)
+	}
+	if g := d.GetGroup("Group"); g == nil || g.FeedLinks[0] != "exec:cat feed.xml" || g.FeedLinks[1] != "https://example.com/feed" {
+		t.Errorf("the group was not migrated: %+v", g)
+	}
+}
+
+func TestRefreshOfRemovedFeed(t *testing.T) {
+	useTempStore(t)
+
+	d := NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"a", "b"} {
+		if err := d.InsertFeed(&fd.Feed{Feed: &gofeed.Feed{Title: link, FeedLink: link}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another process removes both feeds while they are being fetched.
+	other := NewDB()
+	if err := other.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range append([]*fd.Feed{}, other.Feed...) {
+		if err := other.DeleteFeed(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := d.GetFeed("a")
+	if _, err := d.ApplyUpdate(a, a, &fd.Fetch{At: time.Now(), Status: 200}, fd.Retention{}); err != ErrFeedRemoved {
+		t.Errorf("ApplyUpdate: got %v, want ErrFeedRemoved", err)
+	}
+	if _, err := d.RecordFailure(d.GetFeed("b"), &fd.Fetch{At: time.Now(), Status: 500, Error: "boom"}); err != ErrFeedRemoved {
+		t.Errorf("RecordFailure: got %v, want ErrFeedRemoved", err)
+	}
+	if len(d.Feed) != 0 {
+		t.Errorf("%d removed feeds are still loaded", len(d.Feed))
+	}
+
+	d = NewDB()
+	if err := d.LoadFeeds(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Feed) != 0 {
+		t.Errorf("%d removed feeds were saved again", len(d.Feed))
+	}
+}
@@ -0,0 +1,135 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CommandPrefix marks a feed link as a shell command which outputs the feed,
+// as in "exec:cat ~/feed.xml". Links are never run as commands without it.
+const CommandPrefix = "exec:"
+
+// CommandOptions changes how command feeds are run.
+type CommandOptions struct {
+	// Dir is the working directory of the command.
+	Dir string
+	// Env is added to the few variables which commands inherit.
+	Env     map[string]string
+	Timeout time.Duration
+}
+
+// inheritedEnv are the variables passed on to commands. Everything else in
+// the environment of rssviewer, such as tokens, is kept from them.
+var inheritedEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_ALL", "TZ", "TMPDIR",
+	// Needed by Windows programs.
+	"SystemRoot", "TEMP", "TMP", "USERPROFILE",
+}
+
+// maxStderr is how much of the end of the standard error of a failed
+// command is kept in its error.
+const maxStderr = 512
+
+// IsCommand reports whether link is a command rather than a URL.
+func IsCommand(link string) bool {
+	return strings.HasPrefix(link, CommandPrefix)
+}
+
+// CommandLink returns the link of a feed which is the output of command.
+func CommandLink(command string) string {
+	return CommandPrefix + command
+}
+
+// CommandOf returns the command of a command link.
+func CommandOf(link string) string {
+	return strings.TrimPrefix(link, CommandPrefix)
+}
+
+// IsURL reports whether link is an absolute URL.
+func IsURL(link string) bool {
+	return isUrl(link)
+}
+
+// Cmd is a program run while fetching a feed: the command of a command feed,
+// which outputs the feed, or a filter, which rewrites the feed it reads.
+type Cmd struct {
+	Cmd  string
+	Args []string
+}
+
+// ShellCmd returns a Cmd which runs command with the shell.
+func ShellCmd(command string) *Cmd {
+	if runtime.GOOS == "windows" {
+		return &Cmd{Cmd: "powershell.exe", Args: []string{"-Command", command}}
+	}
+	return &Cmd{Cmd: "sh", Args: []string{"-c", command}}
+}
+
+// Run runs c with stdin as its standard input and returns its output. The
+// error of a failed command ends with what it wrote to standard error.
+func (c *Cmd) Run(ctx context.Context, stdin []byte, opts *CommandOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &CommandOptions{}
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.Command(c.Cmd, c.Args...)
+	setProcessGroup(cmd)
+	cmd.Dir = opts.Dir
+	cmd.Env = []string{}
+	for _, name := range inheritedEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+v)
+		}
+	}
+	for name, v := range opts.Env {
+		cmd.Env = append(cmd.Env, name+"="+v)
+	}
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// Killing only the shell would leave its children holding the output
+	// open, and Wait waiting for them.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, errors.Errorf("timed out after %s", opts.Timeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxStderr {
+			msg = "..." + msg[len(msg)-maxStderr:]
+		}
+		if msg != "" {
+			return nil, errors.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCmdRun(t *testing.T) {
+	t.Setenv("FEED_TEST_SECRET", "leaked")
+	dir := t.TempDir()
+	opts := &CommandOptions{Dir: dir, Env: map[string]string{"GREETING": "hello"}, Timeout: time.Second}
+
+	out, err := ShellCmd(`pwd; echo "$GREETING$FEED_TEST_SECRET"`).Run(context.Background(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(out)); len(got) != 2 || !strings.HasSuffix(got[0], dir) || got[1] != "hello" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	_, err = ShellCmd("echo broken >&2; exit 3").Run(context.Background(), nil, opts)
+	if err == nil || !strings.HasSuffix(err.Error(), ": broken") {
+		t.Errorf("the error should end with the standard error, got %v", err)
+	}
+
+	opts.Timeout = 50 * time.Millisecond
+	if _, err := ShellCmd("sleep 5").Run(context.Background(), nil, opts); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestGetFeedNeedsCommandPrefix(t *testing.T) {
+	if _, _, err := GetFeedFromURL(context.Background(), "echo not run", 1, nil); err == nil {
+		t.Fatal("a link without a scheme should not be run")
+	}
+	f, _, err := GetFeedFromURL(context.Background(), CommandLink("cat <<'EOF'\n"+testRSS+"\nEOF"), 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 1 {
+		t.Errorf("items = %d", len(f.Items))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testRSS))
+	}))
+	defer srv.Close()
+
+	f, _, err := GetFeedFromURL(context.Background(), srv.URL, 1, &Options{Filter: "sed s/First/Fixed/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Items[0].Title != "Fixed" {
+		t.Errorf("the filter was not applied: %q", f.Items[0].Title)
+	}
+
+	_, _, err = GetFeedFromURL(context.Background(), srv.URL, 1, &Options{Filter: "exit 1"})
+	if err == nil || !strings.HasPrefix(err.Error(), ErrFilterFailed) {
+		t.Errorf("expected the filter to fail, got %v", err)
+	}
+}
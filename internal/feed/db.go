@@ -3,8 +3,12 @@ package feed
 import (
 	"bytes"
 	"encoding/gob"
+
+	"github.com/pkg/errors"
 )
 
+// EncodeFeed and DecodeFeed read and write the gob encoding used by earlier
+// versions of the store.
 func EncodeFeed(feeds *Feed) ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
 	enc := gob.NewEncoder(buf)
@@ -15,9 +19,16 @@ func EncodeFeed(feeds *Feed) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func DecodeFeed(data []byte) *Feed {
+var ErrEmptyFeed = errors.New("decoded feed is empty")
+
+func DecodeFeed(data []byte) (*Feed, error) {
 	var feeds Feed
 	buf := bytes.NewBuffer(data)
-	_ = gob.NewDecoder(buf).Decode(&feeds)
-	return &feeds
+	if err := gob.NewDecoder(buf).Decode(&feeds); err != nil {
+		return nil, err
+	}
+	if feeds.Feed == nil {
+		return nil, ErrEmptyFeed
+	}
+	return &feeds, nil
 }
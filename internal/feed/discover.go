@@ -0,0 +1,160 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// Candidate is a feed found on a web page.
+type Candidate struct {
+	URL   string
+	Title string
+}
+
+// MultipleFeedsError is returned when a web page offers several feeds, and
+// the user has to choose one of them.
+type MultipleFeedsError struct {
+	URL        string
+	Candidates []*Candidate
+}
+
+func (e *MultipleFeedsError) Error() string {
+	return fmt.Sprintf("%d feeds were found on the page", len(e.Candidates))
+}
+
+// NotFeedError is returned when a downloaded document could not be read as a
+// feed. It keeps the document, so that Discover need not fetch it again.
+type NotFeedError struct {
+	URL  string
+	Body []byte
+	err  error
+}
+
+func (e *NotFeedError) Error() string {
+	return e.err.Error()
+}
+
+var feedLinkTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/feed+json",
+}
+
+// commonFeedPaths are tried, in order, when a page does not link to a feed.
+var commonFeedPaths = []string{
+	"/feed",
+	"/rss",
+	"/feed.xml",
+	"/rss.xml",
+	"/atom.xml",
+	"/index.xml",
+	"/feed.json",
+}
+
+// sitemapPath is tried when a site has no feed at all.
+const sitemapPath = "/sitemap.xml"
+
+// Discover looks for the feeds of the web page at pageURL: those linked
+// with <link rel="alternate">, or else the first one found at a common path,
+// or else the sitemap of the site. page is the page if it has been fetched
+// already, and nil otherwise.
+func Discover(ctx context.Context, pageURL string, page *NotFeedError, opts *HTTPOptions) ([]*Candidate, error) {
+	if page == nil {
+		resp, err := fetchURL(ctx, pageURL, "", "", opts)
+		if err != nil {
+			return nil, err
+		}
+		page = &NotFeedError{URL: pageURL, Body: resp.Body}
+		if resp.MovedTo != "" {
+			page.URL = resp.MovedTo
+		}
+	}
+	base, err := url.Parse(page.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := linkedFeeds(base, page.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) > 0 {
+		return candidates, nil
+	}
+
+	for _, path := range commonFeedPaths {
+		u := base.ResolveReference(&url.URL{Path: path}).String()
+		resp, err := fetchURL(ctx, u, "", "", opts)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil || gofeed.DetectFeedType(bytes.NewReader(resp.Body)) == gofeed.FeedTypeUnknown {
+			continue
+		}
+		title := u
+		if parsed, err := gofeed.NewParser().Parse(bytes.NewReader(resp.Body)); err == nil && parsed.Title != "" {
+			title = parsed.Title
+		}
+		return []*Candidate{{URL: u, Title: title}}, nil
+	}
+
+	u := base.ResolveReference(&url.URL{Path: sitemapPath}).String()
+	if resp, err := fetchURL(ctx, u, "", "", opts); err == nil && isSitemap(resp.Body) {
+		return []*Candidate{{URL: u, Title: "Sitemap of " + base.Host}}, nil
+	}
+	return nil, ctx.Err()
+}
+
+func linkedFeeds(base *url.URL, body []byte) ([]*Candidate, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the page")
+	}
+
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := base.Parse(href); err == nil {
+			base = u
+		}
+	}
+
+	candidates := []*Candidate{}
+	seen := map[string]bool{}
+	doc.Find("link[href]").Each(func(_ int, s *goquery.Selection) {
+		rel := strings.Fields(strings.ToLower(s.AttrOr("rel", "")))
+		if !contains(rel, "alternate") {
+			return
+		}
+		typ := strings.ToLower(strings.TrimSpace(s.AttrOr("type", "")))
+		if !contains(feedLinkTypes, typ) {
+			return
+		}
+		u, err := base.Parse(strings.TrimSpace(s.AttrOr("href", "")))
+		if err != nil || seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+
+		title := strings.TrimSpace(s.AttrOr("title", ""))
+		if title == "" {
+			title = u.String()
+		}
+		candidates = append(candidates, &Candidate{URL: u.String(), Title: title})
+	})
+	return candidates, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blog/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head>
+<link rel="alternate" type="application/rss+xml" title="Posts" href="posts.xml">
+<link rel="alternate" type="application/atom+xml" href="/comments.atom">
+<link rel="stylesheet" type="text/css" href="style.css">
+</head></html>`))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><title>No links</title></head></html>`))
+	})
+	mux.HandleFunc("/atom.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testRSS))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	candidates, err := Discover(context.Background(), srv.URL+"/blog/", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 ||
+		candidates[0].URL != srv.URL+"/blog/posts.xml" || candidates[0].Title != "Posts" ||
+		candidates[1].URL != srv.URL+"/comments.atom" {
+		t.Errorf("unexpected candidates from links: %+v", candidates)
+	}
+
+	candidates, err = Discover(context.Background(), srv.URL+"/plain", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].URL != srv.URL+"/atom.xml" || candidates[0].Title != "Test" {
+		t.Errorf("unexpected candidates from common paths: %+v", candidates)
+	}
+}
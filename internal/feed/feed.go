@@ -1,30 +1,49 @@
 package feed
 
 import (
+	"bytes"
+	"context"
 	"net/url"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"runtime"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/gdamore/tcell/v2"
 	"github.com/mmcdole/gofeed"
 	"github.com/pkg/errors"
 )
 
 var (
-	ErrUrlFailed   = "Parsing URL Failed: "
-	ErrCmdFailed   = "Executing Command Failed: "
-	ErrParseFailed = "Parseing Feed Failed: "
+	ErrUrlFailed     = "Parsing URL Failed: "
+	ErrCmdFailed     = "Executing Command Failed: "
+	ErrParseFailed   = "Parseing Feed Failed: "
+	ErrFilterFailed  = "Filtering Feed Failed: "
+	ErrScrapeFailed  = "Scraping Page Failed: "
+	ErrGitFailed     = "Reading Repository Failed: "
+	ErrMailFailed    = "Reading Mailbox Failed: "
+	ErrSitemapFailed = "Reading Sitemap Failed: "
+	ErrFileFailed    = "Reading File Failed: "
 )
 
 type Feed struct {
 	*gofeed.Feed
-	Color int
-	Items []*Item
+	Color        int
+	Items        []*Item
+	ETag         string
+	LastModified string
+	LastFetched  time.Time
+	TTL          int
+	SkipHours    []int
+	SkipDays     []string
+	// LastError is the error of the last fetch if it failed, in which case
+	// the feed keeps the content of the last successful one.
+	LastError    string
+	LastErrorAt  time.Time
+	FailureCount int
+	// History holds the latest fetches, oldest first.
+	History []*Fetch
+	// Disabled feeds are not refreshed, since the server has said that
+	// they are gone for good.
+	Disabled bool
 }
 
 func isUrl(str string) bool {
@@ -32,61 +51,208 @@ func isUrl(str string) bool {
 	return err == nil && u.Scheme != "" && u.Host != ""
 }
 
-func GetFeedFromURL(url string, color int) (*Feed, error) {
+// Options changes how a feed is fetched. A nil *Options, like its nil
+// fields, means the defaults.
+type Options struct {
+	HTTP    *HTTPOptions
+	Gemini  *GeminiOptions
+	Command *CommandOptions
+	// Filter is a shell command which reads the feed as fetched and writes
+	// the feed to be parsed, to fix it up.
+	Filter string
+	// Scrape makes the feed from a web page rather than parsing it, and
+	// JSON from a JSON document. Sitemap picks the pages of a sitemap,
+	// which is read as one even without it.
+	Scrape  *ScrapeRules
+	JSON    *JSONRules
+	Sitemap *SitemapRules
+	// Shorthands are expanded before DefaultShorthands.
+	Shorthands []*Shorthand
+}
+
+// IsMapped reports whether the items are mapped out of a document by rules
+// rather than parsed from a feed.
+func (o *Options) IsMapped() bool {
+	return o.scrape() != nil || o.json() != nil || o.sitemap() != nil
+}
+
+func (o *Options) http() *HTTPOptions {
+	if o == nil {
+		return nil
+	}
+	return o.HTTP
+}
+
+func (o *Options) gemini() *GeminiOptions {
+	if o == nil {
+		return nil
+	}
+	return o.Gemini
+}
+
+func (o *Options) command() *CommandOptions {
+	if o == nil {
+		return nil
+	}
+	return o.Command
+}
+
+func (o *Options) scrape() *ScrapeRules {
+	if o == nil {
+		return nil
+	}
+	return o.Scrape
+}
+
+func (o *Options) json() *JSONRules {
+	if o == nil {
+		return nil
+	}
+	return o.JSON
+}
+
+func (o *Options) sitemap() *SitemapRules {
+	if o == nil {
+		return nil
+	}
+	return o.Sitemap
+}
+
+func (o *Options) shorthands() []*Shorthand {
+	if o == nil {
+		return nil
+	}
+	return o.Shorthands
+}
+
+func (o *Options) filter() string {
+	if o == nil {
+		return ""
+	}
+	return o.Filter
+}
+
+// GetFeedFromURL fetches the feed at url, which may also be a command link or
+// a local source. The returned Fetch describes the attempt whether or not it
+// succeeded.
+func GetFeedFromURL(ctx context.Context, url string, color int, opts *Options) (*Feed, *Fetch, error) {
+	fetch := &Fetch{At: time.Now()}
+	feed, err := getFeed(ctx, url, color, "", "", opts, fetch)
+	fetch.finish(feed, err)
+	return feed, fetch, err
+}
+
+// UpdateFeed fetches f again. If the server reports that nothing has changed
+// since the last fetch, f itself is returned with its stored items.
+func UpdateFeed(ctx context.Context, f *Feed, opts *Options) (*Feed, *Fetch, error) {
+	fetch := &Fetch{At: time.Now()}
+	newFeed, err := getFeed(ctx, f.FeedLink, f.Color, f.ETag, f.LastModified, opts, fetch)
+	if err == ErrNotModified {
+		f.LastFetched = time.Now()
+		newFeed, err = f, nil
+	}
+	fetch.finish(newFeed, err)
+	return newFeed, fetch, err
+}
+
+func (fetch *Fetch) finish(feed *Feed, err error) {
+	fetch.Latency = time.Since(fetch.At)
+	if err != nil {
+		fetch.Error = err.Error()
+	} else {
+		fetch.Items = len(feed.Items)
+	}
+}
+
+func getFeed(ctx context.Context, link string, color int, etag, lastModified string, opts *Options, fetch *Fetch) (*Feed, error) {
 	var (
 		parsedFeed *gofeed.Feed
 		feed       *Feed
+		resp       *response
+		body       []byte
 		err        error
 	)
-	parser := gofeed.NewParser()
-
-	failureFeed := &Feed{
-		Feed: &gofeed.Feed{
-			Title:    "Error",
-			FeedLink: url,
-		},
-		Color: int(tcell.ColorRed),
-		Items: []*Item{},
-	}
-
-	home, err := os.UserHomeDir()
+	url, err := ExpandShorthand(link, opts.shorthands())
 	if err != nil {
-		panic(err)
+		return nil, errors.Errorf(ErrUrlFailed + err.Error())
 	}
+	defer func() {
+		// The shorthand is kept rather than the URL it expands to.
+		if url != link {
+			fetch.MovedTo = ""
+		}
+	}()
 
-	if isUrl(url) {
-		parsedFeed, err = parser.ParseURL(url)
+	switch {
+	case IsGemini(url) || IsGopher(url):
+		if IsGemini(url) {
+			resp, err = fetchGemini(ctx, url, opts)
+		} else {
+			resp, err = fetchGopher(ctx, url, opts.http())
+		}
+		if resp != nil {
+			fetch.Status = resp.Status
+			fetch.Size = len(resp.Body)
+			fetch.MovedTo = resp.MovedTo
+		}
 		if err != nil {
-			errMsg := ErrUrlFailed + err.Error()
-			failureFeed.Feed.Description = errMsg
-			return failureFeed, errors.Errorf(errMsg)
+			return nil, errors.Errorf(ErrUrlFailed + err.Error())
 		}
-	} else {
-		cmd := Cmd{Cmd: "sh", Args: []string{"-c"}}
-		if runtime.GOOS == "windows" {
-			cmd = Cmd{Cmd: "powershell.exe", Args: []string{"-Command"}}
+		body = resp.Body
+	case isUrl(url):
+		resp, err = fetchURL(ctx, url, etag, lastModified, opts.http())
+		if resp != nil {
+			fetch.Status = resp.Status
+			fetch.Size = len(resp.Body)
+			fetch.MovedTo = resp.MovedTo
 		}
-
-		output, err := exec.Command(cmd.Cmd, append(cmd.Args, url)...).Output()
+		if err == ErrNotModified {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errors.Errorf(ErrUrlFailed + err.Error())
+		}
+		body = resp.Body
+	case IsCommand(url):
+		body, err = ShellCmd(CommandOf(url)).Run(ctx, nil, opts.command())
+		if err != nil {
+			return nil, errors.Errorf(ErrCmdFailed + err.Error())
+		}
+		fetch.Size = len(body)
+	case IsGit(url):
+		parsedFeed, fetch.Size, err = getGitFeed(ctx, url, opts.command())
 		if err != nil {
-			errMsg := ErrCmdFailed + err.Error()
-			failureFeed.Feed.Description = errMsg
-			return failureFeed, errors.Errorf(ErrCmdFailed + err.Error())
+			return nil, errors.Errorf(ErrGitFailed + err.Error())
 		}
-		parsedFeed, err = parser.ParseString(string(output))
+	case IsMail(url):
+		parsedFeed, fetch.Size, err = getMailFeed(url)
 		if err != nil {
-			errMsg := ErrParseFailed + err.Error() + string(output)
-			failureFeed.Feed.Description = errMsg
+			return nil, errors.Errorf(ErrMailFailed + err.Error())
+		}
+	case IsLocal(url):
+		body, parsedFeed, fetch.Size, err = readLocal(url)
+		if err != nil {
+			return nil, errors.Errorf(ErrFileFailed + err.Error())
+		}
+	default:
+		return nil, errors.Errorf("%s is not a URL, an absolute path, a command starting with %s or a local source starting with %s or %s", url, CommandPrefix, GitPrefix, MailPrefix)
+	}
 
-			if err := os.WriteFile(filepath.Join(home, "fd.log"), output, 0755); err != nil {
-				panic(err)
+	if parsedFeed == nil {
+		parsedFeed, err = parseBody(ctx, url, body, resp, opts)
+		if err != nil && resp != nil {
+			pageURL := url
+			if resp.MovedTo != "" {
+				pageURL = resp.MovedTo
 			}
-
-			return failureFeed, errors.Errorf(ErrParseFailed + err.Error())
+			return nil, &NotFeedError{URL: pageURL, Body: body, err: err}
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	parsedFeed.FeedLink = url
+	parsedFeed.FeedLink = link
 
 	rawItems := []*gofeed.Item{}
 	for i := 0; i < len(parsedFeed.Items); i++ {
@@ -102,6 +268,14 @@ func GetFeedFromURL(url string, color int) (*Feed, error) {
 		Color: color,
 		Items: []*Item{},
 	}
+	if resp != nil {
+		feed.ETag = resp.ETag
+		feed.LastModified = resp.LastModified
+	}
+	feed.LastFetched = time.Now()
+	if parsedFeed.FeedType == "rss" {
+		feed.setScheduleHints(body)
+	}
 
 	for _, item := range rawItems {
 		if item.PublishedParsed != nil && time.Now().After(*item.PublishedParsed) {
@@ -119,6 +293,74 @@ func GetFeedFromURL(url string, color int) (*Feed, error) {
 	return feed, nil
 }
 
+// parseBody makes a feed of the body of a fetch, after passing it through
+// the filter of opts. resp is nil unless the body was downloaded.
+func parseBody(ctx context.Context, url string, body []byte, resp *response, opts *Options) (*gofeed.Feed, error) {
+	var (
+		parsedFeed *gofeed.Feed
+		err        error
+	)
+	if filter := opts.filter(); filter != "" {
+		body, err = ShellCmd(filter).Run(ctx, body, opts.command())
+		if err != nil {
+			return nil, errors.Errorf(ErrFilterFailed + err.Error())
+		}
+	}
+
+	switch {
+	case opts.scrape() != nil:
+		pageURL := ""
+		if resp != nil {
+			pageURL = url
+			if resp.MovedTo != "" {
+				pageURL = resp.MovedTo
+			}
+		}
+		parsedFeed, err = scrape(body, pageURL, opts.scrape())
+		if err != nil {
+			return nil, errors.Errorf(ErrScrapeFailed + err.Error())
+		}
+	case opts.json() != nil:
+		parsedFeed, err = mapJSON(body, opts.json())
+		if err != nil {
+			return nil, errors.Errorf(ErrParseFailed + err.Error())
+		}
+		if parsedFeed.Title == "" {
+			parsedFeed.Title = url
+		}
+	case resp != nil && resp.MediaType == geminiMediaType:
+		pageURL := url
+		if resp.MovedTo != "" {
+			pageURL = resp.MovedTo
+		}
+		parsedFeed, err = parseGemfeed(body, pageURL)
+		if err != nil {
+			return nil, errors.Errorf(ErrParseFailed + err.Error())
+		}
+	case resp != nil && resp.MediaType == gopherMenuType:
+		parsedFeed, err = parseGopherMenu(body, url)
+		if err != nil {
+			return nil, errors.Errorf(ErrParseFailed + err.Error())
+		}
+	case opts.sitemap() != nil || isSitemap(body):
+		parsedFeed, err = readSitemap(ctx, url, body, opts)
+		if err != nil {
+			return nil, errors.Errorf(ErrSitemapFailed + err.Error())
+		}
+	default:
+		parsedFeed, err = gofeed.NewParser().Parse(bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Errorf(ErrParseFailed + err.Error())
+		}
+	}
+	return parsedFeed, nil
+}
+
+// IsStale reports whether the last fetch of f failed.
+func (f *Feed) IsStale() bool {
+	return f.FailureCount > 0
+}
+
 func SortItems(items []*Item) {
 	sort.Slice(items, func(i, j int) bool {
 		a := items[i]
@@ -139,7 +381,24 @@ func (f *Feed) SetColor(color int) {
 	}
 }
 
-type Cmd struct {
-	Cmd  string
-	Args []string
+// SetLink changes the URL of f, for a feed which has moved.
+func (f *Feed) SetLink(link string) {
+	f.FeedLink = link
+	for _, item := range f.Items {
+		item.Belong = link
+	}
+}
+
+func (f *Feed) UnreadCount() int {
+	return CountUnread(f.Items)
+}
+
+func CountUnread(items []*Item) int {
+	c := 0
+	for _, item := range items {
+		if !item.IsRead {
+			c++
+		}
+	}
+	return c
 }
@@ -0,0 +1,110 @@
+package feed
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+const userAgent = "Gofeed/1.0"
+
+var ErrNotModified = errors.New("feed not modified")
+
+var httpClient = &http.Client{}
+
+// maxRedirects is the limit of net/http's default redirect policy.
+const maxRedirects = 10
+
+type response struct {
+	Status int
+	// MovedTo is the final URL if every redirect on the way was permanent.
+	MovedTo      string
+	Body         []byte
+	ETag         string
+	LastModified string
+	// MediaType is set by Gemini and Gopher, whose documents are not only
+	// told apart by their content.
+	MediaType string
+}
+
+// fetchURL downloads url, sending the validators of the previous response
+// so that an unchanged feed costs a 304 instead of a full download. The
+// response is returned along with ErrNotModified and HTTP errors, for its
+// status.
+func fetchURL(ctx context.Context, url, etag, lastModified string, opts *HTTPOptions) (*response, error) {
+	if opts != nil && opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.apply(req); err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	movedTo := ""
+	isPermanent := true
+	c, err := opts.client()
+	if err != nil {
+		return nil, err
+	}
+	client := *c
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return errors.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		switch req.Response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		default:
+			isPermanent = false
+		}
+		movedTo = req.URL.String()
+		return nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !isPermanent {
+		movedTo = ""
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return &response{Status: resp.StatusCode, MovedTo: movedTo}, ErrNotModified
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &response{Status: resp.StatusCode, MovedTo: movedTo}, gofeed.HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response{
+		Status:       resp.StatusCode,
+		MovedTo:      movedTo,
+		Body:         body,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
@@ -0,0 +1,122 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testRSS = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test</title>
+<item><title>First</title><link>https://example.com/1</link><guid>1</guid><pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate></item>
+</channel></rss>`
+
+func TestUpdateFeedNotModified(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testRSS))
+	}))
+	defer srv.Close()
+
+	f, _, err := GetFeedFromURL(context.Background(), srv.URL, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ETag != `"v1"` || len(f.Items) != 1 {
+		t.Fatalf("unexpected feed: etag=%q items=%d", f.ETag, len(f.Items))
+	}
+
+	updated, fetch, err := UpdateFeed(context.Background(), f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != f {
+		t.Errorf("304 response should keep the stored feed")
+	}
+	if fetch.Status != http.StatusNotModified || fetch.Items != 1 || fetch.Error != "" {
+		t.Errorf("unexpected fetch: %+v", fetch)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+}
+
+func TestUpdateFeedRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/feed", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/found", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved", http.StatusFound)
+	})
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testRSS))
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	_, fetch, err := GetFeedFromURL(context.Background(), srv.URL+"/moved", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetch.MovedTo != srv.URL+"/feed" {
+		t.Errorf("MovedTo = %q after a permanent redirect", fetch.MovedTo)
+	}
+
+	_, fetch, err = GetFeedFromURL(context.Background(), srv.URL+"/found", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetch.MovedTo != "" {
+		t.Errorf("MovedTo = %q after a temporary redirect", fetch.MovedTo)
+	}
+
+	f := &Feed{}
+	_, fetch, err = GetFeedFromURL(context.Background(), srv.URL+"/gone", 1, nil)
+	if err == nil {
+		t.Fatal("a feed which is gone should fail")
+	}
+	f.AddFetch(fetch)
+	if !f.Disabled {
+		t.Errorf("a feed which is gone should be disabled")
+	}
+}
+
+func TestFetchWithHTTPOptions(t *testing.T) {
+	t.Setenv("FEED_TEST_PASSWORD", "s3cret")
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte(testRSS))
+	}))
+	defer srv.Close()
+
+	opts := &HTTPOptions{
+		UserAgent: "test-agent",
+		Header:    map[string]string{"X-Api-Key": "env:FEED_TEST_PASSWORD"},
+		Username:  "me",
+		Password:  "env:FEED_TEST_PASSWORD",
+	}
+	if _, _, err := GetFeedFromURL(context.Background(), srv.URL, 1, &Options{HTTP: opts}); err != nil {
+		t.Fatal(err)
+	}
+	if ua := got.Header.Get("User-Agent"); ua != "test-agent" {
+		t.Errorf("User-Agent = %q", ua)
+	}
+	if key := got.Header.Get("X-Api-Key"); key != "s3cret" {
+		t.Errorf("X-Api-Key = %q, want the resolved secret", key)
+	}
+	if user, password, ok := got.BasicAuth(); !ok || user != "me" || password != "s3cret" {
+		t.Errorf("basic auth = %q %q %v", user, password, ok)
+	}
+}
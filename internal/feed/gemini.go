@@ -0,0 +1,371 @@
+package feed
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// GeminiOptions sets how gemini:// feeds are fetched. The timeout and the
+// client certificate are those of the HTTP options.
+type GeminiOptions struct {
+	// KnownHosts is the file where the certificates of servers are pinned
+	// when first seen. Without one, they are pinned until the program ends.
+	KnownHosts string
+}
+
+const (
+	geminiMediaType = "text/gemini"
+	// maxSmallWebBody limits the response of a Gemini or Gopher server,
+	// which has no other way of saying how long it is.
+	maxSmallWebBody = 16 << 20
+	// smallWebTimeout applies when the HTTP options set no timeout.
+	smallWebTimeout    = 60 * time.Second
+	maxGeminiRedirects = 5
+)
+
+// IsGemini reports whether link is a gemini:// URL.
+func IsGemini(link string) bool {
+	return strings.HasPrefix(strings.ToLower(link), "gemini://")
+}
+
+// fetchGemini requests link from its Gemini server, following redirects.
+// The media type of the body is returned with it.
+func fetchGemini(ctx context.Context, link string, opts *Options) (*response, error) {
+	ctx, cancel := smallWebContext(ctx, opts.http())
+	defer cancel()
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	isPermanent := true
+	for redirects := 0; ; redirects++ {
+		status, meta, body, err := geminiRequest(ctx, u, opts)
+		if err != nil {
+			return nil, err
+		}
+		switch status / 10 {
+		case 2:
+			if meta == "" {
+				meta = geminiMediaType
+			}
+			mediaType, params, err := mime.ParseMediaType(meta)
+			if err != nil {
+				return nil, errors.Wrapf(err, "media type %q", meta)
+			}
+			if charset := params["charset"]; charset != "" && !strings.EqualFold(charset, "utf-8") {
+				if r, err := charsetReader(charset, bytes.NewReader(body)); err == nil {
+					body, _ = io.ReadAll(r)
+				}
+			}
+			resp := &response{Status: status, Body: body, MediaType: mediaType}
+			if redirects > 0 && isPermanent {
+				resp.MovedTo = u.String()
+			}
+			return resp, nil
+		case 3:
+			if redirects >= maxGeminiRedirects {
+				return nil, errors.Errorf("stopped after %d redirects", maxGeminiRedirects)
+			}
+			next, err := u.Parse(meta)
+			if err != nil {
+				return nil, errors.Wrapf(err, "redirect to %q", meta)
+			}
+			if next.Scheme != "gemini" {
+				return nil, errors.Errorf("redirect to %s", next)
+			}
+			isPermanent = isPermanent && status == 31
+			u = next
+		case 1:
+			return nil, errors.Errorf("status %d: the server asks for input: %s", status, meta)
+		case 6:
+			return nil, errors.Errorf("status %d: the server asks for a client certificate: %s", status, meta)
+		default:
+			// 52 GONE is recorded as 410, so that the feed is disabled like
+			// an HTTP one.
+			resp := &response{Status: status}
+			if status == 52 {
+				resp.Status = http.StatusGone
+			}
+			return resp, errors.Errorf("status %d: %s", status, meta)
+		}
+	}
+}
+
+// geminiRequest makes one request and returns the status, the meta of the
+// header and, on success, the body.
+func geminiRequest(ctx context.Context, u *url.URL, opts *Options) (int, string, []byte, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "1965")
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: u.Hostname(),
+		// Servers mostly use self-signed certificates, which are pinned
+		// instead of verified.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no certificate")
+			}
+			return checkPin(opts.gemini().knownHosts(), addr, cs.PeerCertificates[0])
+		},
+	}
+	if h := opts.http(); h != nil && h.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(h.CertFile, h.KeyFile)
+		if err != nil {
+			return 0, "", nil, errors.Wrap(err, "loading the client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	if _, err := io.WriteString(conn, u.String()+"\r\n"); err != nil {
+		return 0, "", nil, err
+	}
+	lr := &io.LimitedReader{R: conn, N: maxSmallWebBody + 1}
+	r := bufio.NewReader(lr)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return 0, "", nil, errors.Wrap(err, "reading the header")
+	}
+	code, meta, _ := strings.Cut(strings.TrimRight(header, "\r\n"), " ")
+	status, err := strconv.Atoi(code)
+	if err != nil || len(code) != 2 {
+		return 0, "", nil, errors.Errorf("invalid header %q", header)
+	}
+	if status/10 != 2 {
+		return status, strings.TrimSpace(meta), nil, nil
+	}
+	// Servers often close without a TLS close_notify, which still ends the
+	// body with io.EOF. Any other error means that the body is incomplete.
+	body, err := io.ReadAll(r)
+	if err := smallWebReadErr(ctx, lr, err); err != nil {
+		return 0, "", nil, err
+	}
+	return status, strings.TrimSpace(meta), body, nil
+}
+
+// smallWebReadErr returns the error of reading a response through lr, which
+// allows one byte more than maxSmallWebBody. A longer response is an error
+// rather than being cut short.
+func smallWebReadErr(ctx context.Context, lr *io.LimitedReader, err error) error {
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return ctx.Err()
+	case err != nil:
+		return err
+	case lr.N <= 0:
+		return errors.Errorf("the response is larger than %d bytes", maxSmallWebBody)
+	}
+	return nil
+}
+
+func (o *GeminiOptions) knownHosts() string {
+	if o == nil {
+		return ""
+	}
+	return o.KnownHosts
+}
+
+// smallWebContext applies the timeout of opts, or smallWebTimeout.
+func smallWebContext(ctx context.Context, opts *HTTPOptions) (context.Context, context.CancelFunc) {
+	timeout := smallWebTimeout
+	if opts != nil && opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// closeOnDone closes conn when ctx is done, to interrupt reads and writes,
+// until the returned function is called.
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+var (
+	pinsMu sync.Mutex
+	// memoryPins are the pins of servers when there is no known hosts
+	// file.
+	memoryPins = map[string]*pin{}
+)
+
+// pin is the fingerprint of the public key of a server, and when its
+// certificate expires.
+type pin struct {
+	fingerprint string
+	notAfter    time.Time
+}
+
+// checkPin trusts the certificate of the server at addr on first use, and
+// later only if it has the same key as then. A new key is accepted once the
+// certificate which was pinned has expired.
+func checkPin(knownHosts, addr string, cert *x509.Certificate) error {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	seen := &pin{fingerprint: "sha256:" + hex.EncodeToString(sum[:]), notAfter: cert.NotAfter}
+
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+
+	pins := memoryPins
+	if knownHosts != "" {
+		var err error
+		if pins, err = readPins(knownHosts); err != nil {
+			return err
+		}
+	}
+	known, ok := pins[addr]
+	switch {
+	case ok && known.fingerprint == seen.fingerprint && known.notAfter.Equal(seen.notAfter):
+		return nil
+	case ok && known.fingerprint != seen.fingerprint && time.Now().Before(known.notAfter):
+		hint := ""
+		if knownHosts != "" {
+			hint = "; remove it from " + knownHosts + " if the change is expected"
+		}
+		return errors.Errorf("the certificate of %s has changed since it was first seen%s", addr, hint)
+	}
+	pins[addr] = seen
+	if knownHosts != "" {
+		return writePins(knownHosts, pins)
+	}
+	return nil
+}
+
+// readPins reads a known hosts file, which has a line of
+// "HOST:PORT sha256:FINGERPRINT EXPIRY" for each server.
+func readPins(path string) (map[string]*pin, error) {
+	pins := map[string]*pin{}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		pins[fields[0]] = &pin{fingerprint: fields[1], notAfter: time.Unix(expiry, 0)}
+	}
+	return pins, nil
+}
+
+func writePins(path string, pins map[string]*pin) error {
+	var b strings.Builder
+	for addr, p := range pins {
+		b.WriteString(addr + " " + p.fingerprint + " " + strconv.FormatInt(p.notAfter.Unix(), 10) + "\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(b.String()), 0600)
+}
+
+// parseGemfeed makes a feed of a gemlog index page, following the gemfeed
+// convention: the first heading is the title, and each link whose label
+// starts with a date is a post.
+func parseGemfeed(body []byte, pageURL string) (*gofeed.Feed, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	feed := &gofeed.Feed{Link: pageURL, FeedType: "gemfeed"}
+	isPreformatted := false
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "```") {
+			isPreformatted = !isPreformatted
+			continue
+		}
+		switch {
+		case isPreformatted:
+		case feed.Title == "" && strings.HasPrefix(line, "# "):
+			feed.Title = strings.TrimSpace(line[2:])
+		case strings.HasPrefix(line, "=>"):
+			fields := strings.Fields(line[2:])
+			if len(fields) < 2 {
+				continue
+			}
+			date, title, ok := datedLabel(strings.Join(fields[1:], " "))
+			if !ok {
+				continue
+			}
+			u, err := base.Parse(fields[0])
+			if err != nil {
+				continue
+			}
+			feed.Items = append(feed.Items, &gofeed.Item{
+				GUID:            u.String(),
+				Link:            u.String(),
+				Title:           title,
+				PublishedParsed: &date,
+			})
+		}
+	}
+	if len(feed.Items) == 0 {
+		return nil, errors.New("the page is neither a feed nor a gemlog with dated links")
+	}
+	if feed.Title == "" {
+		feed.Title = base.Host + base.Path
+	}
+	return feed, nil
+}
+
+// datedLabel reads the date at the start of the label of a link, like
+// "2026-10-01 - Title", and returns the rest as the title.
+func datedLabel(label string) (time.Time, string, bool) {
+	if len(label) < len("2006-01-02") {
+		return time.Time{}, "", false
+	}
+	date, err := time.ParseInLocation("2006-01-02", label[:10], time.Local)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	title := strings.TrimSpace(strings.TrimLeft(label[10:], " \t-–—:|"))
+	if title == "" {
+		title = label[:10]
+	}
+	return date, title, true
+}
@@ -0,0 +1,122 @@
+package feed
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serve answers each connection to l with respond, which gets the request
+// line.
+func serve(l net.Listener, respond func(req string) string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			req, _ := bufio.NewReader(conn).ReadString('\n')
+			io.WriteString(conn, respond(strings.TrimRight(req, "\r\n")))
+		}()
+	}
+}
+
+func TestGeminiFeed(t *testing.T) {
+	cert := selfSigned(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	base := "gemini://" + l.Addr().String()
+	go serve(l, func(req string) string {
+		switch strings.TrimPrefix(req, base) {
+		case "/gemlog/":
+			return "20 text/gemini; charset=utf-8\r\n" +
+				"# My Gemlog\n\n=> /about About me\n" +
+				"=> 2026-10-02-hello.gmi 2026-10-02 - Hello, world\n" +
+				"```\n=> not-a-link.gmi 2026-10-03 Inside a preformatted block\n```\n" +
+				"=> gemini://example.org/post.gmi 2026-09-30 Elsewhere\n"
+		case "/old":
+			return "31 /gemlog/\r\n"
+		case "/atom.xml":
+			return "20 application/atom+xml\r\n" + `<feed xmlns="http://www.w3.org/2005/Atom"><title>Atom</title>` +
+				`<entry><id>1</id><title>Entry</title><updated>2026-10-01T00:00:00Z</updated></entry></feed>`
+		case "/gone":
+			return "52 Gone\r\n"
+		}
+		return "51 Not found\r\n"
+	})
+
+	opts := &Options{Gemini: &GeminiOptions{KnownHosts: filepath.Join(t.TempDir(), "known_hosts")}}
+	f, fetch, err := GetFeedFromURL(context.Background(), base+"/old", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "My Gemlog" || len(f.Items) != 2 || fetch.MovedTo != base+"/gemlog/" {
+		t.Fatalf("unexpected feed %q with %d items, moved to %q", f.Title, len(f.Items), fetch.MovedTo)
+	}
+	if item := f.Items[0]; item.Title != "Hello, world" || item.Link != base+"/gemlog/2026-10-02-hello.gmi" {
+		t.Errorf("unexpected item: %+v", item)
+	}
+
+	if f, _, err := GetFeedFromURL(context.Background(), base+"/atom.xml", 1, opts); err != nil || f.Title != "Atom" || len(f.Items) != 1 {
+		t.Errorf("unexpected result: %v", err)
+	}
+	if _, fetch, err := GetFeedFromURL(context.Background(), base+"/missing", 1, opts); err == nil || !strings.Contains(err.Error(), "51") || fetch.Status != 51 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// A feed which is gone is disabled.
+	gone := &Feed{}
+	_, fetch, err = GetFeedFromURL(context.Background(), base+"/gone", 1, opts)
+	if err == nil {
+		t.Fatal("a feed which is gone should fail")
+	}
+	gone.AddFetch(fetch)
+	if !gone.Disabled {
+		t.Errorf("a feed which is gone should be disabled: %+v", fetch)
+	}
+
+	// The server has been pinned, and a new key is refused.
+	l.Close()
+	other, err := tls.Listen("tcp", l.Addr().String(), &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}})
+	if err != nil {
+		t.Skip("the port was taken:", err)
+	}
+	defer other.Close()
+	go serve(other, func(string) string { return "20 text/gemini\r\n=> a 2026-10-01 A\n" })
+	if _, _, err := GetFeedFromURL(context.Background(), base+"/gemlog/", 1, opts); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("a changed certificate was not refused: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package feed
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+	"github.com/yitose/rssviewer/pkg/util"
+)
+
+// GitPrefix marks a feed link as a local git repository, whose commits are
+// the items: "git:PATH", optionally followed by "?branch=BRANCH&path=PATH"
+// to follow a branch other than HEAD or only the commits touching a path.
+const GitPrefix = "git:"
+
+// maxGitCommits is how many commits are read per fetch. Older ones are
+// kept in the history like the items of any other feed.
+const maxGitCommits = 100
+
+const (
+	gitFieldSep  = "\x1f"
+	gitRecordSep = "\x1e"
+)
+
+// IsGit reports whether link is a git repository.
+func IsGit(link string) bool {
+	return strings.HasPrefix(link, GitPrefix)
+}
+
+type gitSource struct {
+	repo   string
+	branch string
+	path   string
+}
+
+func parseGitLink(link string) (*gitSource, error) {
+	rest := strings.TrimPrefix(link, GitPrefix)
+	repo, query, _ := strings.Cut(rest, "?")
+	if repo == "" {
+		return nil, errors.New("no repository path")
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(values.Get("branch"), "-") {
+		return nil, errors.Errorf("invalid branch %q", values.Get("branch"))
+	}
+	return &gitSource{
+		repo:   util.ExpandHome(repo),
+		branch: values.Get("branch"),
+		path:   values.Get("path"),
+	}, nil
+}
+
+// getGitFeed reads the latest commits of the repository at link.
+func getGitFeed(ctx context.Context, link string, opts *CommandOptions) (*gofeed.Feed, int, error) {
+	src, err := parseGitLink(link)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	format := strings.Join([]string{"%H", "%an", "%ae", "%aI", "%s", "%b"}, gitFieldSep) + gitRecordSep
+	args := []string{"-C", src.repo, "log", "-n", strconv.Itoa(maxGitCommits), "--format=" + format}
+	if src.branch != "" {
+		args = append(args, src.branch)
+	}
+	args = append(args, "--")
+	if src.path != "" {
+		args = append(args, src.path)
+	}
+	out, err := (&Cmd{Cmd: "git", Args: args}).Run(ctx, nil, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	title := filepath.Base(src.repo)
+	if src.branch != "" {
+		title += " (" + src.branch + ")"
+	}
+	if src.path != "" {
+		title += " " + src.path
+	}
+	feed := &gofeed.Feed{Title: title, Link: src.repo, FeedType: "git"}
+	for _, record := range strings.Split(string(out), gitRecordSep) {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), gitFieldSep, 6)
+		if len(fields) != 6 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[3])
+		if err != nil {
+			continue
+		}
+		author := &gofeed.Person{Name: fields[1], Email: fields[2]}
+		feed.Items = append(feed.Items, &gofeed.Item{
+			GUID:            fields[0],
+			Title:           fields[4],
+			Description:     strings.TrimSpace(fields[5]),
+			Author:          author,
+			Authors:         []*gofeed.Person{author},
+			PublishedParsed: &date,
+		})
+	}
+	return feed, len(out), nil
+}
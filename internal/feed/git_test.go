@@ -0,0 +1,68 @@
+package feed
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitFeed(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+			"GIT_AUTHOR_DATE=2026-10-01T12:00:00Z", "GIT_COMMITTER_DATE=2026-10-01T12:00:00Z")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	commit := func(file, msg string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(repo, file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(repo, file), []byte(msg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", ".")
+		git("commit", "-q", "-m", msg)
+	}
+	git("init", "-q", "-b", "main")
+	commit("README", "Add readme\n\nWith a body.")
+	commit("docs/guide", "Write the guide")
+
+	f, _, err := GetFeedFromURL(context.Background(), GitPrefix+repo, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 2 || f.Title != filepath.Base(repo) {
+		t.Fatalf("unexpected feed %q with %d items", f.Title, len(f.Items))
+	}
+	var readme *Item
+	for _, item := range f.Items {
+		if item.Title == "Add readme" {
+			readme = item
+		}
+	}
+	if readme == nil || readme.Description != "With a body." || readme.Author.Name != "Alice" || len(readme.GUID) != 40 {
+		t.Errorf("unexpected item: %+v", readme)
+	}
+
+	f, _, err = GetFeedFromURL(context.Background(), GitPrefix+repo+"?branch=main&path=docs", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 1 || f.Items[0].Title != "Write the guide" {
+		t.Errorf("the path was not applied: %d items", len(f.Items))
+	}
+
+	if _, _, err := GetFeedFromURL(context.Background(), GitPrefix+repo+"?branch=--output=x", 1, nil); err == nil {
+		t.Error("a branch which is an option should be refused")
+	}
+}
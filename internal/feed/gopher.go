@@ -0,0 +1,143 @@
+package feed
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// gopherMenuType is the media type given to Gopher menus, which are turned
+// into feeds. Other Gopher documents are parsed like any feed.
+const gopherMenuType = "application/gopher-menu"
+
+// IsGopher reports whether link is a gopher:// URL.
+func IsGopher(link string) bool {
+	return strings.HasPrefix(strings.ToLower(link), "gopher://")
+}
+
+// fetchGopher requests the document at link, a URL of the form
+// gopher://HOST[:PORT]/TYPE SELECTOR, from its Gopher server.
+func fetchGopher(ctx context.Context, link string, opts *HTTPOptions) (*response, error) {
+	ctx, cancel := smallWebContext(ctx, opts)
+	defer cancel()
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	itemType, selector := gopherPath(u.Path)
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "70")
+	}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	if _, err := io.WriteString(conn, selector+"\r\n"); err != nil {
+		return nil, err
+	}
+	lr := &io.LimitedReader{R: conn, N: maxSmallWebBody + 1}
+	body, err := io.ReadAll(lr)
+	if err := smallWebReadErr(ctx, lr, err); err != nil {
+		return nil, err
+	}
+
+	resp := &response{Body: body}
+	if itemType == '1' {
+		resp.MediaType = gopherMenuType
+	}
+	return resp, nil
+}
+
+// gopherPath splits the path of a Gopher URL into the item type and the
+// selector. An empty path is the root menu.
+func gopherPath(path string) (byte, string) {
+	if len(path) < 2 {
+		return '1', ""
+	}
+	return path[1], path[2:]
+}
+
+// parseGopherMenu makes a feed of the entries of a Gopher menu. If any entry
+// starts with a date, as on a phlog, only those are items, dated by it;
+// otherwise every entry is, dated when first seen.
+func parseGopherMenu(body []byte, link string) (*gofeed.Feed, error) {
+	feed := &gofeed.Feed{Link: link, FeedType: "gopher"}
+	dated := []*gofeed.Item{}
+	undated := []*gofeed.Item{}
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "." {
+			break
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line[1:], "\t")
+		if len(fields) < 4 {
+			continue
+		}
+		display, selector, host, port := fields[0], fields[1], fields[2], fields[3]
+		switch line[0] {
+		case 'i':
+			if feed.Title == "" {
+				feed.Title = strings.TrimSpace(display)
+			}
+			continue
+		case '2', '3', '7', '8', 'T', '+':
+			// Not documents: lookups, errors, searches, telnet and mirrors.
+			continue
+		}
+
+		item := &gofeed.Item{Title: strings.TrimSpace(display)}
+		if line[0] == 'h' && strings.HasPrefix(selector, "URL:") {
+			item.Link = strings.TrimPrefix(selector, "URL:")
+		} else {
+			u := &url.URL{Scheme: "gopher", Host: host, Path: "/" + string(line[0]) + selector}
+			if port != "70" {
+				u.Host = net.JoinHostPort(host, port)
+			}
+			item.Link = u.String()
+		}
+		item.GUID = item.Link
+
+		if date, title, ok := datedLabel(item.Title); ok {
+			item.Title = title
+			item.PublishedParsed = &date
+			dated = append(dated, item)
+		} else {
+			undated = append(undated, item)
+		}
+	}
+
+	if len(dated) > 0 {
+		feed.Items = dated
+	} else {
+		now := time.Now()
+		for i, item := range undated {
+			t := now.Add(-time.Duration(i) * time.Second)
+			item.PublishedParsed = &t
+			item.Custom = map[string]string{customDateUnknown: "true"}
+		}
+		feed.Items = undated
+	}
+	if len(feed.Items) == 0 {
+		return nil, errors.New("the menu has no entries")
+	}
+	if feed.Title == "" {
+		feed.Title = link
+	}
+	return feed, nil
+}
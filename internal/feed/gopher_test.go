@@ -0,0 +1,57 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestGopherFeed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	go serve(l, func(req string) string {
+		switch req {
+		case "/phlog":
+			return "iMy Phlog\t\terror.host\t1\r\n" +
+				fmt.Sprintf("02026-10-02 Second post\t/phlog/2.txt\t%s\t%s\r\n", host, port) +
+				fmt.Sprintf("02026-10-01 First post\t/phlog/1.txt\t%s\t%s\r\n", host, port) +
+				fmt.Sprintf("1Home\t\t%s\t%s\r\n", host, port) +
+				".\r\n"
+		case "/large":
+			return strings.Repeat("i\t\terror.host\t1\r\n", maxSmallWebBody/16+1)
+		case "":
+			return fmt.Sprintf("1Phlog\t/phlog\t%s\t%s\r\nhWebsite\tURL:https://example.com/\t%s\t%s\r\n.\r\n", host, port, host, port)
+		}
+		return "3Not found\t\terror.host\t1\r\n.\r\n"
+	})
+	base := "gopher://" + l.Addr().String()
+
+	f, _, err := GetFeedFromURL(context.Background(), base+"/1/phlog", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "My Phlog" || len(f.Items) != 2 {
+		t.Fatalf("unexpected feed %q with %d items", f.Title, len(f.Items))
+	}
+	if item := f.Items[0]; item.Title != "Second post" || item.Link != base+"/0/phlog/2.txt" {
+		t.Errorf("unexpected item: %+v", item)
+	}
+
+	f, _, err = GetFeedFromURL(context.Background(), base, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 2 || f.Items[1].Link != "https://example.com/" {
+		t.Fatalf("unexpected items: %+v", f.Items)
+	}
+
+	if _, _, err := GetFeedFromURL(context.Background(), base+"/1/large", 1, nil); err == nil {
+		t.Error("expected an error for a response over the limit")
+	}
+}
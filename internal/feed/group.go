@@ -34,9 +34,11 @@ func EncodeGroup(g *Group) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func DecodeGroup(data []byte) *Group {
+func DecodeGroup(data []byte) (*Group, error) {
 	var g Group
 	buf := bytes.NewBuffer(data)
-	_ = gob.NewDecoder(buf).Decode(&g)
-	return &g
+	if err := gob.NewDecoder(buf).Decode(&g); err != nil {
+		return nil, err
+	}
+	return &g, nil
 }
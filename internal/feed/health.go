@@ -0,0 +1,100 @@
+package feed
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MaxFetchHistory is the number of fetches remembered for each feed.
+const MaxFetchHistory = 20
+
+// Fetch describes one attempt to fetch a feed.
+type Fetch struct {
+	At time.Time
+	// Status is the HTTP status, or 0 for commands and requests which got
+	// no response.
+	Status  int
+	Latency time.Duration
+	// Size is the length of the response body in bytes.
+	Size  int
+	Items int
+	// Error is empty if the fetch succeeded.
+	Error string
+	// MovedTo is the new URL of a feed which has been permanently
+	// redirected.
+	MovedTo string
+}
+
+// AddFetch appends fetch to the history of f, and marks f as stale or
+// healthy depending on its outcome. A feed which is gone is disabled.
+func (f *Feed) AddFetch(fetch *Fetch) {
+	f.History = append(f.History, fetch)
+	if n := len(f.History) - MaxFetchHistory; n > 0 {
+		f.History = f.History[n:]
+	}
+
+	if fetch.Status == http.StatusGone {
+		f.Disabled = true
+	}
+
+	if fetch.Error == "" {
+		f.LastError = ""
+		f.LastErrorAt = time.Time{}
+		f.FailureCount = 0
+		return
+	}
+	f.LastError = fetch.Error
+	f.LastErrorAt = fetch.At
+	f.FailureCount++
+}
+
+// StatusText is the HTTP status of fetch, or whether it succeeded if it had
+// none.
+func (fetch *Fetch) StatusText() string {
+	switch {
+	case fetch.Status != 0:
+		return fmt.Sprint(fetch.Status)
+	case fetch.Error != "":
+		return "error"
+	}
+	return "ok"
+}
+
+// LastFetch returns the latest entry in the history of f, or nil.
+func (f *Feed) LastFetch() *Fetch {
+	if len(f.History) == 0 {
+		return nil
+	}
+	return f.History[len(f.History)-1]
+}
+
+// LastPublished returns when the newest item of f was published, or nil.
+func (f *Feed) LastPublished() *time.Time {
+	var last *time.Time
+	for _, item := range f.Items {
+		if item.PublishedParsed != nil && (last == nil || item.PublishedParsed.After(*last)) {
+			last = item.PublishedParsed
+		}
+	}
+	return last
+}
+
+// HasProblem reports whether f is disabled, has failed maxFailures times in a
+// row, or has not published anything for maxSilence. A zero limit is not
+// checked.
+func (f *Feed) HasProblem(maxFailures int, maxSilence time.Duration, now time.Time) bool {
+	if f.Disabled {
+		return true
+	}
+	if maxFailures > 0 && f.FailureCount >= maxFailures {
+		return true
+	}
+	if maxSilence > 0 {
+		last := f.LastPublished()
+		if last == nil || now.Sub(*last) > maxSilence {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package feed
+
+import "time"
+
+// Retention limits how much of a feed's history is kept. Zero values mean
+// no limit.
+type Retention struct {
+	MaxAge   time.Duration
+	MaxItems int
+}
+
+// customDateUnknown marks an item whose publisher gives no date, which is
+// dated by when it was fetched instead. The mark is not stored, so a stored
+// item keeps the date it was first seen with.
+const customDateUnknown = "rssviewer:dateUnknown"
+
+// Key identifies an item across refreshes.
+func (i *Item) Key() string {
+	if i.GUID != "" {
+		return i.GUID
+	}
+	if i.Link != "" {
+		return i.Link
+	}
+	return i.Title
+}
+
+// MergeHistory adds the items of old which are no longer served by the
+// publisher to f, and then drops history exceeding r. Items in the latest
+// fetch are always kept.
+func (f *Feed) MergeHistory(old *Feed, r Retention) {
+	latestItems := map[string]*Item{}
+	for _, item := range f.Items {
+		latestItems[item.Key()] = item
+	}
+
+	items := append([]*Item{}, f.Items...)
+	isMerged := map[string]bool{}
+	for _, item := range old.Items {
+		if latest, ok := latestItems[item.Key()]; ok {
+			latest.IsRead = item.IsRead
+			if latest.Custom[customDateUnknown] != "" {
+				latest.PublishedParsed = item.PublishedParsed
+			}
+		} else if !isMerged[item.Key()] {
+			item.Belong = f.FeedLink
+			items = append(items, item)
+			isMerged[item.Key()] = true
+		}
+	}
+
+	SortItems(items)
+
+	limit := time.Now().Add(-r.MaxAge)
+	f.Items = []*Item{}
+	for _, item := range items {
+		if _, ok := latestItems[item.Key()]; !ok {
+			if r.MaxAge > 0 && item.PublishedParsed.Before(limit) {
+				continue
+			}
+			if r.MaxItems > 0 && len(f.Items) >= r.MaxItems {
+				continue
+			}
+		}
+		f.Items = append(f.Items, item)
+	}
+}
@@ -0,0 +1,69 @@
+package feed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func newTestItem(guid string, published time.Time) *Item {
+	return &Item{Item: &gofeed.Item{GUID: guid, Title: guid, PublishedParsed: &published}}
+}
+
+func TestMergeHistory(t *testing.T) {
+	now := time.Now()
+	old := &Feed{Feed: &gofeed.Feed{FeedLink: "f"}, Items: []*Item{
+		newTestItem("b", now.Add(-2*time.Hour)),
+		newTestItem("c", now.Add(-3*time.Hour)),
+		newTestItem("d", now.Add(-100*24*time.Hour)),
+	}}
+	latest := &Feed{Feed: &gofeed.Feed{FeedLink: "f"}, Items: []*Item{
+		newTestItem("a", now.Add(-time.Hour)),
+		newTestItem("b", now.Add(-2*time.Hour)),
+	}}
+
+	latest.MergeHistory(old, Retention{MaxAge: 30 * 24 * time.Hour})
+
+	keys := []string{}
+	for _, item := range latest.Items {
+		keys = append(keys, item.Key())
+	}
+	want := []string{"a", "b", "c"}
+	if len(keys) != len(want) {
+		t.Fatalf("items = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("items = %v, want %v", keys, want)
+		}
+	}
+}
+
+func TestMergeHistoryKeepsLatest(t *testing.T) {
+	now := time.Now()
+	old := &Feed{Feed: &gofeed.Feed{}, Items: []*Item{newTestItem("b", now.Add(-time.Hour))}}
+	latest := &Feed{Feed: &gofeed.Feed{}, Items: []*Item{
+		newTestItem("a", now.Add(-48*time.Hour)),
+	}}
+
+	latest.MergeHistory(old, Retention{MaxAge: time.Hour / 2, MaxItems: 1})
+
+	if len(latest.Items) != 1 || latest.Items[0].Key() != "a" {
+		t.Errorf("latest items must survive retention, got %d items", len(latest.Items))
+	}
+}
+
+func TestMergeHistoryKeepsFirstSeenDate(t *testing.T) {
+	now := time.Now()
+	firstSeen := now.Add(-24 * time.Hour)
+	old := &Feed{Feed: &gofeed.Feed{}, Items: []*Item{newTestItem("a", firstSeen)}}
+	latest := &Feed{Feed: &gofeed.Feed{}, Items: []*Item{newTestItem("a", now)}}
+	latest.Items[0].Custom = map[string]string{customDateUnknown: "true"}
+
+	latest.MergeHistory(old, Retention{})
+
+	if !latest.Items[0].PublishedParsed.Equal(firstSeen) {
+		t.Errorf("an undated item should keep the date it was first seen with")
+	}
+}
@@ -0,0 +1,140 @@
+package feed
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yitose/rssviewer/internal/secret"
+)
+
+// HTTPOptions changes how feeds are requested. Password, BearerToken and
+// header values may be secret references, which are resolved when a request
+// is made. The zero value requests feeds like a plain http.Client.
+type HTTPOptions struct {
+	UserAgent   string
+	Header      map[string]string
+	Username    string
+	Password    string
+	BearerToken string
+	// Proxy is an http, https or socks5 URL.
+	Proxy   string
+	Timeout time.Duration
+	CAFile  string
+	// CertFile and KeyFile are a client certificate in PEM.
+	CertFile string
+	KeyFile  string
+}
+
+var (
+	clientsMu sync.Mutex
+	// clients share connections between feeds with the same transport
+	// settings.
+	clients = map[string]*http.Client{}
+)
+
+func (o *HTTPOptions) client() (*http.Client, error) {
+	if o == nil || (o.Proxy == "" && o.CAFile == "" && o.CertFile == "") {
+		return httpClient, nil
+	}
+
+	key := strings.Join([]string{o.Proxy, o.CAFile, o.CertFile, o.KeyFile}, "\x00")
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if c, ok := clients[key]; ok {
+		return c, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing the proxy URL")
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if o.CAFile != "" || o.CertFile != "" {
+		tlsConfig, err := o.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	c := &http.Client{Transport: transport}
+	clients[key] = c
+	return c, nil
+}
+
+func (o *HTTPOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the CA file")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("%s has no certificates", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading the client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// apply sets the headers and credentials of o on req.
+func (o *HTTPOptions) apply(req *http.Request) error {
+	req.Header.Set("User-Agent", userAgent)
+	if o == nil {
+		return nil
+	}
+
+	if o.UserAgent != "" {
+		req.Header.Set("User-Agent", o.UserAgent)
+	}
+	for name, value := range o.Header {
+		v, err := resolve(value)
+		if err != nil {
+			return errors.Wrapf(err, "header %s", name)
+		}
+		req.Header.Set(name, v)
+	}
+	if o.Username != "" {
+		password, err := resolve(o.Password)
+		if err != nil {
+			return errors.Wrap(err, "password")
+		}
+		req.SetBasicAuth(o.Username, password)
+	}
+	if o.BearerToken != "" {
+		token, err := resolve(o.BearerToken)
+		if err != nil {
+			return errors.Wrap(err, "bearer token")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+func resolve(value string) (string, error) {
+	if !secret.IsRef(value) {
+		return value, nil
+	}
+	return secret.Resolve(value)
+}
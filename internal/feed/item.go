@@ -8,4 +8,5 @@ type Item struct {
 	*gofeed.Item
 	Belong string
 	Color  int
+	IsRead bool
 }
@@ -0,0 +1,152 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// JSONRules build the items of a feed from a JSON document which is not a
+// JSON Feed. Items is the path of the items, and the other paths are
+// relative to each item, except FeedTitle. See jsonPath for the syntax.
+type JSONRules struct {
+	Items string
+	Title string
+	Link  string
+	// Date is either text in one of DateLayouts or the common formats, or a
+	// Unix time in seconds or milliseconds. Items without a date are dated
+	// when they are first seen.
+	Date        string
+	DateLayouts []string
+	Author      string
+	Description string
+	// ID identifies an item across fetches, and defaults to the link.
+	ID        string
+	FeedTitle string
+}
+
+type compiledJSONRules struct {
+	items, title, link, date, author, description, id, feedTitle jsonPath
+}
+
+// Validate reports a missing items path or a path which does not parse.
+func (r *JSONRules) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+func (r *JSONRules) compile() (*compiledJSONRules, error) {
+	if r.Items == "" {
+		return nil, errors.New("no items path")
+	}
+	c := &compiledJSONRules{}
+	for _, p := range []struct {
+		path *jsonPath
+		s    string
+	}{
+		{&c.items, r.Items},
+		{&c.title, r.Title},
+		{&c.link, r.Link},
+		{&c.date, r.Date},
+		{&c.author, r.Author},
+		{&c.description, r.Description},
+		{&c.id, r.ID},
+		{&c.feedTitle, r.FeedTitle},
+	} {
+		if p.s == "" {
+			continue
+		}
+		path, err := parseJSONPath(p.s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "path %q", p.s)
+		}
+		*p.path = path
+	}
+	return c, nil
+}
+
+// mapJSON builds a feed from a JSON document with r.
+func mapJSON(body []byte, r *JSONRules) (*gofeed.Feed, error) {
+	c, err := r.compile()
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	// Keep large IDs exact.
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	values := c.items.eval(doc)
+	if len(values) == 1 {
+		if array, ok := values[0].([]interface{}); ok {
+			values = array
+		}
+	}
+
+	feed := &gofeed.Feed{FeedType: "json"}
+	if c.feedTitle != nil {
+		feed.Title = c.feedTitle.String(doc)
+	}
+	now := time.Now()
+	for _, v := range values {
+		item := &gofeed.Item{}
+		if c.title != nil {
+			item.Title = c.title.String(v)
+		}
+		if c.link != nil {
+			item.Link = c.link.String(v)
+		}
+		if c.description != nil {
+			item.Description = c.description.String(v)
+		}
+		if c.id != nil {
+			item.GUID = c.id.String(v)
+		}
+		if c.author != nil {
+			if name := c.author.String(v); name != "" {
+				item.Author = &gofeed.Person{Name: name}
+				item.Authors = []*gofeed.Person{item.Author}
+			}
+		}
+
+		date := ""
+		if c.date != nil {
+			date = c.date.String(v)
+		}
+		if t, ok := parseJSONDate(date, r.DateLayouts); ok {
+			item.PublishedParsed = &t
+		} else {
+			t := now.Add(-time.Duration(len(feed.Items)) * time.Second)
+			item.PublishedParsed = &t
+			item.Custom = map[string]string{customDateUnknown: "true"}
+		}
+
+		if item.Title == "" && item.Link == "" {
+			continue
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	if len(feed.Items) == 0 {
+		return nil, errors.Errorf("no items at %q", r.Items)
+	}
+	return feed, nil
+}
+
+func parseJSONDate(s string, layouts []string) (time.Time, bool) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// Milliseconds since the epoch are beyond the year 33658 in seconds.
+		if n > 1e12 {
+			return time.UnixMilli(n).In(time.Local), true
+		}
+		return time.Unix(n, 0).In(time.Local), true
+	}
+	return parseDate(s, layouts)
+}
@@ -0,0 +1,59 @@
+package feed
+
+import (
+	"testing"
+	"time"
+)
+
+const testJSON = `{"project": {"name": "Tool"}, "data": {"releases": [
+	{"id": 9007199254740993, "tag": "v2.0", "url": "https://example.com/v2", "published": "2026-10-01T12:00:00Z", "author": {"login": "alice"}, "notes": "Big"},
+	{"id": 2, "tag": "v1.0", "url": "https://example.com/v1", "published": 1759000000},
+	{"id": 3, "tag": "nightly"},
+	{"id": 4}
+]}}`
+
+func TestMapJSON(t *testing.T) {
+	rules := &JSONRules{
+		Items:       "$.data.releases[*]",
+		Title:       "tag",
+		Link:        "url",
+		Date:        "published",
+		Author:      "author.login",
+		Description: "['notes']",
+		ID:          "id",
+		FeedTitle:   "project.name",
+	}
+	f, err := mapJSON([]byte(testJSON), rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "Tool" || len(f.Items) != 3 {
+		t.Fatalf("unexpected feed %q with %d items", f.Title, len(f.Items))
+	}
+
+	first := f.Items[0]
+	if first.Title != "v2.0" || first.Link != "https://example.com/v2" || first.Author.Name != "alice" || first.Description != "Big" || first.GUID != "9007199254740993" {
+		t.Errorf("unexpected item: %+v", first)
+	}
+	if !first.PublishedParsed.Equal(time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("date = %v", first.PublishedParsed)
+	}
+	if second := f.Items[1]; second.PublishedParsed.Unix() != 1759000000 {
+		t.Errorf("a Unix time was not read: %v", second.PublishedParsed)
+	}
+	if third := f.Items[2]; third.Custom[customDateUnknown] == "" {
+		t.Errorf("an item without a date should be marked")
+	}
+
+	// The array itself selects its elements too.
+	rules.Items = "data.releases"
+	if f, err := mapJSON([]byte(testJSON), rules); err != nil || len(f.Items) != 3 {
+		t.Errorf("items = %v, %v", f, err)
+	}
+
+	for _, path := range []string{"a..b", "a[", "a[x]"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("%q should not parse", path)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package feed
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// jsonPath is a path into decoded JSON in a subset of JSONPath: an optional
+// "$" followed by ".name", "['name']", "[index]" and the wildcards ".*" and
+// "[*]", as in "$.data.releases[*]" or "assets[0].url".
+type jsonPath []pathStep
+
+type pathStep struct {
+	name  string
+	index int
+	// isIndex steps into an array, and isWildcard into every member.
+	isIndex    bool
+	isWildcard bool
+}
+
+func parseJSONPath(s string) (jsonPath, error) {
+	path := jsonPath{}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			name := s[:end]
+			s = s[end:]
+			switch name {
+			case "":
+				return nil, errors.New("empty name")
+			case "*":
+				path = append(path, pathStep{isWildcard: true})
+			default:
+				path = append(path, pathStep{name: name})
+			}
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, errors.New("missing ]")
+			}
+			inner := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+			switch {
+			case inner == "*":
+				path = append(path, pathStep{isWildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				path = append(path, pathStep{name: inner[1 : len(inner)-1]})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, errors.Errorf("invalid index %q", inner)
+				}
+				path = append(path, pathStep{index: i, isIndex: true})
+			}
+		default:
+			// A leading name without a dot.
+			if len(path) > 0 {
+				return nil, errors.Errorf("unexpected %q", s)
+			}
+			s = "." + s
+		}
+	}
+	return path, nil
+}
+
+// eval returns every value at p in v. Steps which do not apply, such as a
+// name in an array, match nothing rather than failing.
+func (p jsonPath) eval(v interface{}) []interface{} {
+	values := []interface{}{v}
+	for _, step := range p {
+		next := []interface{}{}
+		for _, v := range values {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if step.isWildcard {
+					for _, member := range v {
+						next = append(next, member)
+					}
+				} else if member, ok := v[step.name]; ok && !step.isIndex {
+					next = append(next, member)
+				}
+			case []interface{}:
+				if step.isWildcard {
+					next = append(next, v...)
+					continue
+				}
+				i := step.index
+				if i < 0 {
+					i += len(v)
+				}
+				if step.isIndex && i >= 0 && i < len(v) {
+					next = append(next, v[i])
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// String returns the first value at p in v as text, or "" if there is none.
+func (p jsonPath) String(v interface{}) string {
+	values := p.eval(v)
+	if len(values) == 0 {
+		return ""
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package feed
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/yitose/rssviewer/pkg/util"
+)
+
+// FilePrefix marks a feed link as a local file or directory, as does an
+// absolute path or one starting with "~/". A file is parsed as a feed, and
+// the files in a directory are the items.
+const FilePrefix = "file://"
+
+const (
+	// maxLocalItems is how many files of a directory are read per fetch,
+	// the most recently modified first.
+	maxLocalItems = 500
+	// maxNoteSize is the size up to which a text file is shown as the
+	// description of its item.
+	maxNoteSize = 64 << 10
+)
+
+// textExtensions are the files whose first heading is their title.
+var textExtensions = map[string]bool{
+	".md":       true,
+	".markdown": true,
+	".txt":      true,
+	".gmi":      true,
+	".org":      true,
+	".rst":      true,
+	".adoc":     true,
+}
+
+// IsLocal reports whether link is a local file or directory.
+func IsLocal(link string) bool {
+	return strings.HasPrefix(link, FilePrefix) || strings.HasPrefix(link, "~/") || filepath.IsAbs(link)
+}
+
+// localPath returns the path of a local link.
+func localPath(link string) (string, error) {
+	if !strings.HasPrefix(link, FilePrefix) {
+		return util.ExpandHome(link), nil
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	path := u.Path
+	// file:///C:/feed.xml
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path), nil
+}
+
+// fileURL returns the file:// URL of path.
+func fileURL(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// readLocal returns the content of the file at link, or nil and the feed of
+// its files if it is a directory.
+func readLocal(link string) ([]byte, *gofeed.Feed, int, error) {
+	path, err := localPath(link)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if !info.IsDir() {
+		body, err := os.ReadFile(path)
+		return body, nil, len(body), err
+	}
+	feed, size, err := readDirectory(path)
+	return nil, feed, size, err
+}
+
+type localFile struct {
+	path string
+	info fs.FileInfo
+}
+
+// readDirectory makes a feed of the files under dir, skipping hidden ones.
+// An item is dated when its file was modified, and a file modified again
+// comes back as a new item.
+func readDirectory(dir string) (*gofeed.Feed, int, error) {
+	files := []*localFile{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, &localFile{path: path, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].info.ModTime().After(files[j].info.ModTime())
+	})
+	if len(files) > maxLocalItems {
+		files = files[:maxLocalItems]
+	}
+
+	feed := &gofeed.Feed{Title: filepath.Base(dir), Link: fileURL(dir), FeedType: "directory"}
+	size := 0
+	for _, f := range files {
+		item, n := f.item(dir)
+		feed.Items = append(feed.Items, item)
+		size += n
+	}
+	return feed, size, nil
+}
+
+// item makes the item of f, and returns how many bytes of it were read.
+func (f *localFile) item(dir string) (*gofeed.Item, int) {
+	modTime := f.info.ModTime()
+	link := fileURL(f.path)
+	item := &gofeed.Item{
+		GUID:            link + "#" + modTime.UTC().Format(time.RFC3339),
+		Link:            link,
+		Title:           f.path,
+		PublishedParsed: &modTime,
+	}
+	if rel, err := filepath.Rel(dir, f.path); err == nil {
+		item.Title = filepath.ToSlash(rel)
+	}
+
+	if !textExtensions[strings.ToLower(filepath.Ext(f.path))] || f.info.Size() > maxNoteSize {
+		return item, 0
+	}
+	b, err := os.ReadFile(f.path)
+	if err != nil || !utf8.Valid(b) {
+		return item, len(b)
+	}
+	if title := firstHeading(b, strings.ToLower(filepath.Ext(f.path))); title != "" {
+		item.Title = title
+	}
+	item.Description = strings.TrimSpace(string(b))
+	return item, len(b)
+}
+
+// firstHeading returns the title of a text document: the title of its front
+// matter, or its first heading in the markup of ext.
+func firstHeading(b []byte, ext string) string {
+	lines := strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n")
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for _, line := range lines[1:] {
+			if strings.TrimSpace(line) == "---" {
+				break
+			}
+			if key, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) == "title" {
+				return strings.Trim(strings.TrimSpace(value), `"'`)
+			}
+		}
+	}
+
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case ext == ".org":
+			if strings.HasPrefix(line, "* ") {
+				return strings.TrimSpace(line[2:])
+			}
+		case ext == ".adoc":
+			if strings.HasPrefix(line, "= ") {
+				return strings.TrimSpace(line[2:])
+			}
+		case strings.HasPrefix(line, "#"):
+			if title := strings.TrimLeft(line, "#"); strings.HasPrefix(title, " ") {
+				return strings.TrimSpace(title)
+			}
+		case line != "" && i+1 < len(lines):
+			// A title underlined with "=".
+			if next := strings.TrimSpace(lines[i+1]); len(next) >= 3 && strings.Trim(next, "=") == "" {
+				return line
+			}
+		}
+	}
+	return ""
+}
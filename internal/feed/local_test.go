@@ -0,0 +1,77 @@
+package feed
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalFeed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "feed.xml")
+	if err := os.WriteFile(path, []byte(testRSS), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{path, fileURL(path)} {
+		f, _, err := GetFeedFromURL(context.Background(), link, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(f.Items) != 1 || f.FeedLink != link {
+			t.Errorf("unexpected feed from %s: %+v", link, f.Items)
+		}
+	}
+	if _, _, err := GetFeedFromURL(context.Background(), filepath.Join(dir, "missing.xml"), 1, nil); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestDirectoryFeed(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string, age time.Duration) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("notes/idea.md", "Some text\n\n## Idea\n\nBody", time.Hour)
+	write("notes/meeting.md", "---\ntitle: \"Weekly meeting\"\n---\n# Agenda\n", 2*time.Hour)
+	write("build/app.tar.gz", "\x1f\x8b", 3*time.Hour)
+	write("README.txt", "Read me\n=======\n", 4*time.Hour)
+	write(".git/HEAD", "ref: refs/heads/main", 0)
+
+	f, _, err := GetFeedFromURL(context.Background(), dir, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, item := range f.Items {
+		titles = append(titles, item.Title)
+	}
+	if len(titles) != 4 || titles[0] != "Idea" || titles[1] != "Weekly meeting" || titles[2] != "build/app.tar.gz" || titles[3] != "Read me" {
+		t.Fatalf("unexpected items: %q", titles)
+	}
+	if idea := f.Items[0]; idea.Link != fileURL(filepath.Join(dir, "notes", "idea.md")) || idea.Description != "Some text\n\n## Idea\n\nBody" {
+		t.Errorf("unexpected item: %+v", idea)
+	}
+
+	// A modified file is read again as a new item.
+	key := f.Items[0].Key()
+	write("notes/idea.md", "# Idea, revised", 0)
+	f, _, err = GetFeedFromURL(context.Background(), dir, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Items[0].Title != "Idea, revised" || f.Items[0].Key() == key {
+		t.Errorf("unexpected item: %+v", f.Items[0])
+	}
+}
@@ -0,0 +1,443 @@
+package feed
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+	"github.com/yitose/rssviewer/pkg/util"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// MailPrefix marks a feed link as a local mailbox, an mbox file or a Maildir
+// folder: "mail:PATH", followed by "?list=LIST-ID" or "?from=ADDRESS" for
+// the messages of one mailing list or sender.
+const MailPrefix = "mail:"
+
+// IsMail reports whether link is a mailbox.
+func IsMail(link string) bool {
+	return strings.HasPrefix(link, MailPrefix)
+}
+
+type mailSource struct {
+	path string
+	list string
+	from string
+}
+
+func parseMailLink(link string) (*mailSource, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(link, MailPrefix), "?")
+	if path == "" {
+		return nil, errors.New("no mailbox path")
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return &mailSource{
+		path: util.ExpandHome(path),
+		list: values.Get("list"),
+		from: strings.ToLower(values.Get("from")),
+	}, nil
+}
+
+func (s *mailSource) link(list, from string) string {
+	values := url.Values{}
+	if list != "" {
+		values.Set("list", list)
+	} else {
+		values.Set("from", from)
+	}
+	return MailPrefix + s.path + "?" + values.Encode()
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	e, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return e.NewDecoder().Reader(input), nil
+}
+
+// mailMessage is a message with the headers used to sort it into feeds.
+type mailMessage struct {
+	list     string
+	listName string
+	from     *mail.Address
+	item     *gofeed.Item
+}
+
+func parseMessage(raw []byte) (*mailMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	m := &mailMessage{from: &mail.Address{}}
+	parser := &mail.AddressParser{WordDecoder: wordDecoder}
+	if from, err := parser.Parse(msg.Header.Get("From")); err == nil {
+		m.from = from
+		m.from.Address = strings.ToLower(from.Address)
+	}
+	m.list, m.listName = parseListID(msg.Header.Get("List-Id"))
+	m.item = m.newItem(msg)
+	return m, nil
+}
+
+var listIDPattern = regexp.MustCompile(`^(.*?)\s*<([^>]+)>\s*$`)
+
+// parseListID returns the identifier and the name of a List-Id header like
+// "Weekly News <weekly.example.com>".
+func parseListID(header string) (id, name string) {
+	header = decodeWords(header)
+	if m := listIDPattern.FindStringSubmatch(header); m != nil {
+		return strings.ToLower(m[2]), strings.Trim(m[1], `" `)
+	}
+	return strings.ToLower(strings.TrimSpace(header)), ""
+}
+
+func decodeWords(s string) string {
+	if decoded, err := wordDecoder.DecodeHeader(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// title returns the name of the feed m belongs to.
+func (m *mailMessage) title() string {
+	switch {
+	case m.list != "" && m.listName != "":
+		return m.listName
+	case m.list != "":
+		return m.list
+	case m.from.Name != "":
+		return m.from.Name
+	}
+	return m.from.Address
+}
+
+func (m *mailMessage) matches(s *mailSource) bool {
+	switch {
+	case s.list != "":
+		return m.list == s.list
+	case s.from != "":
+		return m.list == "" && m.from.Address == s.from
+	}
+	return true
+}
+
+// readMailbox returns the raw messages of the Maildir folder or mbox file at
+// path, and how many bytes were read.
+func readMailbox(path string) ([][]byte, int, error) {
+	if !util.IsDir(path) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return splitMbox(b), len(b), nil
+	}
+
+	messages := [][]byte{}
+	size := 0
+	found := false
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(path, sub))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		found = true
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(path, sub, e.Name()))
+			if err != nil {
+				return nil, 0, err
+			}
+			messages = append(messages, b)
+			size += len(b)
+		}
+	}
+	if !found {
+		return nil, 0, errors.Errorf("%s is neither an mbox file nor a Maildir folder", path)
+	}
+	return messages, size, nil
+}
+
+// mailbox is a parsed mailbox. stamp tells whether the files have changed
+// since.
+type mailbox struct {
+	stamp    string
+	size     int
+	messages []*mailMessage
+	expiry   *time.Timer
+}
+
+// mailboxHoldTime is how long a mailbox stays cached after it was last read,
+// so that one which is no longer subscribed does not stay in memory.
+const mailboxHoldTime = time.Hour
+
+// mailboxes caches the last parsed state of each mailbox by path, so that
+// the feeds of its lists and senders read it only once while it is
+// unchanged.
+var (
+	mailboxesMu sync.Mutex
+	mailboxes   = map[string]*mailbox{}
+)
+
+// mailboxStamp describes the modification times and sizes of the mbox file,
+// or the Maildir folders, at path.
+func mailboxStamp(path string) (string, error) {
+	paths := []string{path}
+	if util.IsDir(path) {
+		paths = append(paths, filepath.Join(path, "cur"), filepath.Join(path, "new"))
+	}
+	stamp := ""
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) && p != path {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%s %d %d\n", p, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return stamp, nil
+}
+
+// loadMailbox returns the parsed messages of the mailbox at path, reading it
+// again only if it has changed since the last call.
+func loadMailbox(path string) (*mailbox, error) {
+	mailboxesMu.Lock()
+	defer mailboxesMu.Unlock()
+
+	stamp, err := mailboxStamp(path)
+	if err != nil {
+		return nil, err
+	}
+	old, ok := mailboxes[path]
+	if ok && old.stamp == stamp {
+		old.expiry.Reset(mailboxHoldTime)
+		return old, nil
+	}
+	if ok {
+		old.expiry.Stop()
+	}
+
+	raws, size, err := readMailbox(path)
+	if err != nil {
+		return nil, err
+	}
+	mb := &mailbox{stamp: stamp, size: size}
+	for _, raw := range raws {
+		if m, err := parseMessage(raw); err == nil {
+			mb.messages = append(mb.messages, m)
+		}
+	}
+	mb.expiry = time.AfterFunc(mailboxHoldTime, func() {
+		mailboxesMu.Lock()
+		defer mailboxesMu.Unlock()
+		if mailboxes[path] == mb {
+			delete(mailboxes, path)
+		}
+	})
+	mailboxes[path] = mb
+	return mb, nil
+}
+
+var mboxFromEscape = regexp.MustCompile(`(?m)^>(>*From )`)
+
+// splitMbox splits an mbox file at its "From " lines, undoing the quoting of
+// such lines within messages.
+func splitMbox(b []byte) [][]byte {
+	messages := [][]byte{}
+	for _, part := range bytes.Split(append([]byte("\n"), b...), []byte("\nFrom ")) {
+		// Drop the rest of the "From " line.
+		i := bytes.IndexByte(part, '\n')
+		if i < 0 {
+			continue
+		}
+		msg := bytes.TrimSpace(part[i+1:])
+		if len(msg) == 0 {
+			continue
+		}
+		messages = append(messages, mboxFromEscape.ReplaceAll(msg, []byte("$1")))
+	}
+	return messages
+}
+
+// MailFeeds returns the links of the feeds in the mailbox at link, one per
+// mailing list or sender, unless link selects one already.
+func MailFeeds(link string) ([]*Candidate, error) {
+	src, err := parseMailLink(link)
+	if err != nil {
+		return nil, err
+	}
+	if src.list != "" || src.from != "" {
+		return nil, nil
+	}
+	mb, err := loadMailbox(src.path)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []*Candidate{}
+	isAdded := map[string]bool{}
+	for _, m := range mb.messages {
+		if m.list == "" && m.from.Address == "" {
+			continue
+		}
+		u := src.link(m.list, m.from.Address)
+		if !isAdded[u] {
+			candidates = append(candidates, &Candidate{URL: u, Title: m.title()})
+			isAdded[u] = true
+		}
+	}
+	return candidates, nil
+}
+
+// getMailFeed reads the messages of the mailbox at link.
+func getMailFeed(link string) (*gofeed.Feed, int, error) {
+	src, err := parseMailLink(link)
+	if err != nil {
+		return nil, 0, err
+	}
+	mb, err := loadMailbox(src.path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	feed := &gofeed.Feed{Title: filepath.Base(src.path), FeedType: "mail"}
+	for _, m := range mb.messages {
+		if !m.matches(src) {
+			continue
+		}
+		if src.list != "" || src.from != "" {
+			feed.Title = m.title()
+		}
+		feed.Items = append(feed.Items, m.copyItem())
+	}
+	return feed, mb.size, nil
+}
+
+// copyItem returns a copy of the item of m, which is cached and shared by
+// every fetch of the mailbox.
+func (m *mailMessage) copyItem() *gofeed.Item {
+	item := *m.item
+	if m.item.PublishedParsed != nil {
+		date := *m.item.PublishedParsed
+		item.PublishedParsed = &date
+	}
+	if m.item.Custom != nil {
+		item.Custom = map[string]string{}
+		for k, v := range m.item.Custom {
+			item.Custom[k] = v
+		}
+	}
+	return &item
+}
+
+func (m *mailMessage) newItem(msg *mail.Message) *gofeed.Item {
+	item := &gofeed.Item{
+		GUID:  strings.Trim(msg.Header.Get("Message-Id"), "<> "),
+		Title: decodeWords(msg.Header.Get("Subject")),
+	}
+	if item.GUID == "" {
+		// Without an ID, recurring subjects like "Weekly digest" would be
+		// taken for the same item.
+		sum := sha256.Sum256([]byte(msg.Header.Get("Date") + "\n" + msg.Header.Get("From") + "\n" + msg.Header.Get("Subject")))
+		item.GUID = "mail:" + hex.EncodeToString(sum[:])
+	}
+	if m.from.Address != "" {
+		item.Author = &gofeed.Person{Name: m.from.Name, Email: m.from.Address}
+		item.Authors = []*gofeed.Person{item.Author}
+	}
+	date, err := msg.Header.Date()
+	if err != nil {
+		date = time.Now()
+		item.Custom = map[string]string{customDateUnknown: "true"}
+	}
+	item.PublishedParsed = &date
+
+	body, isHTML := messageBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if isHTML {
+		item.Content = body
+		if doc, err := goquery.NewDocumentFromReader(strings.NewReader(body)); err == nil {
+			doc.Find("script, style, head").Remove()
+			item.Description = collapseSpace(doc.Text())
+		}
+	} else {
+		item.Content = "<pre>" + html.EscapeString(body) + "</pre>"
+		item.Description = strings.TrimSpace(body)
+	}
+	return item
+}
+
+// messageBody returns the HTML part of a message if it has one, and its text
+// otherwise, decoded to UTF-8.
+func messageBody(contentType, encoding string, r io.Reader) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		text := ""
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			// multipart.Reader decodes quoted-printable itself.
+			body, isHTML := messageBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if isHTML {
+				return body, true
+			}
+			if text == "" {
+				text = body
+			}
+		}
+		return text, false
+	}
+	if !strings.HasPrefix(mediaType, "text/") {
+		return "", false
+	}
+
+	switch strings.ToLower(encoding) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	if charset := params["charset"]; charset != "" {
+		if cr, err := charsetReader(charset, r); err == nil {
+			r = cr
+		}
+	}
+	b, _ := io.ReadAll(r)
+	return string(b), mediaType == "text/html"
+}
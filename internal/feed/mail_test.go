@@ -0,0 +1,190 @@
+package feed
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMbox = `From news@weekly.example.com Thu Oct  1 12:00:00 2026
+From: Weekly News <news@weekly.example.com>
+List-Id: "Weekly News" <weekly.example.com>
+Message-Id: <1@weekly.example.com>
+Date: Thu, 01 Oct 2026 12:00:00 +0000
+Subject: =?UTF-8?Q?Issue_=E2=84=961?=
+Content-Type: multipart/alternative; boundary="b"
+
+--b
+Content-Type: text/plain; charset=utf-8
+
+Plain issue one.
+--b
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+<p>Issue <b>one</b> =E2=80=94 hello</p>
+--b--
+
+From bob@example.com Fri Oct  2 12:00:00 2026
+From: Bob <Bob@Example.com>
+Message-Id: <2@example.com>
+Date: Fri, 02 Oct 2026 12:00:00 +0000
+Subject: Notes
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: base64
+
+SGVsbG8gJiB3ZWxjb21lCg==
+
+From news@weekly.example.com Sat Oct  3 12:00:00 2026
+From: Weekly News <news@weekly.example.com>
+List-Id: "Weekly News" <weekly.example.com>
+Message-Id: <3@weekly.example.com>
+Date: Sat, 03 Oct 2026 12:00:00 +0000
+Subject: Issue 2
+
+>From the editor.
+`
+
+func TestMailFeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(testMbox), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, err := MailFeeds(MailPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 ||
+		candidates[0].URL != MailPrefix+path+"?list=weekly.example.com" || candidates[0].Title != "Weekly News" ||
+		candidates[1].URL != MailPrefix+path+"?from=bob%40example.com" || candidates[1].Title != "Bob" {
+		t.Fatalf("unexpected candidates: %+v, %+v", candidates[0], candidates[1])
+	}
+
+	f, _, err := GetFeedFromURL(context.Background(), candidates[0].URL, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "Weekly News" || len(f.Items) != 2 {
+		t.Fatalf("unexpected feed %q with %d items", f.Title, len(f.Items))
+	}
+	// Items are sorted newest first.
+	if f.Items[0].Title != "Issue 2" || f.Items[0].Description != "From the editor." {
+		t.Errorf("unexpected item: %+v", f.Items[0])
+	}
+	if f.Items[1].Title != "Issue №1" || f.Items[1].GUID != "1@weekly.example.com" ||
+		!strings.Contains(f.Items[1].Content, "<b>one</b> — hello") || f.Items[1].Description != "Issue one — hello" {
+		t.Errorf("unexpected item: %+v", f.Items[1])
+	}
+
+	f, _, err = GetFeedFromURL(context.Background(), candidates[1].URL, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 1 || f.Items[0].Content != "<pre>Hello &amp; welcome\n</pre>" {
+		t.Fatalf("unexpected feed: %+v", f.Items)
+	}
+}
+
+func TestMaildirFeed(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, msg := range splitMbox([]byte(testMbox)) {
+		sub := "cur"
+		if i == 0 {
+			sub = "new"
+		}
+		if err := os.WriteFile(filepath.Join(dir, sub, string(rune('a'+i))), msg, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, _, err := GetFeedFromURL(context.Background(), MailPrefix+dir+"?from=bob@example.com", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "Bob" || len(f.Items) != 1 || f.Items[0].Title != "Notes" {
+		t.Fatalf("unexpected feed %q: %+v", f.Title, f.Items)
+	}
+
+	if _, _, err := GetFeedFromURL(context.Background(), MailPrefix+t.TempDir(), 1, nil); err == nil {
+		t.Error("expected an error for a folder which is not a Maildir")
+	}
+}
+
+func TestMailWithoutMessageID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	digest := func(day string) string {
+		return "From digest@example.com " + day + "\nFrom: digest@example.com\nDate: " + day + "\nSubject: Weekly digest\n\nNews.\n\n"
+	}
+	if err := os.WriteFile(path, []byte(digest("Thu, 01 Oct 2026 12:00:00 +0000")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mb, err := loadMailbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := loadMailbox(path); err != nil || cached != mb {
+		t.Errorf("an unchanged mailbox was read again")
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(digest("Thu, 08 Oct 2026 12:00:00 +0000"))
+	f.Close()
+
+	feed, _, err := GetFeedFromURL(context.Background(), MailPrefix+path+"?from=digest@example.com", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 2 || feed.Items[0].GUID == "" || feed.Items[0].Key() == feed.Items[1].Key() {
+		t.Errorf("recurring subjects should be separate items: %+v", feed.Items)
+	}
+}
+
+func TestMailboxCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(testMbox), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fetch may change its items without touching the cached ones.
+	f, _, err := getMailFeed(MailPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*f.Items[0].PublishedParsed = time.Time{}
+	f, _, err = getMailFeed(MailPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Items[0].PublishedParsed.IsZero() {
+		t.Error("the date of a cached item was changed")
+	}
+
+	// A mailbox which is not read again is dropped.
+	mb, err := loadMailbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb.expiry.Reset(0)
+	for i := 0; i < 100; i++ {
+		mailboxesMu.Lock()
+		_, ok := mailboxes[path]
+		mailboxesMu.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("the mailbox was not dropped from the cache")
+}
@@ -0,0 +1,69 @@
+package feed
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// setScheduleHints reads <ttl>, <skipHours> and <skipDays>, which the
+// universal gofeed.Feed does not carry, from an RSS document.
+func (f *Feed) setScheduleHints(body []byte) {
+	parser := rss.Parser{}
+	raw, err := parser.Parse(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	f.TTL, _ = strconv.Atoi(strings.TrimSpace(raw.TTL))
+	f.SkipHours = []int{}
+	for _, h := range raw.SkipHours {
+		if hour, err := strconv.Atoi(strings.TrimSpace(h)); err == nil {
+			f.SkipHours = append(f.SkipHours, hour)
+		}
+	}
+	f.SkipDays = []string{}
+	for _, d := range raw.SkipDays {
+		f.SkipDays = append(f.SkipDays, strings.TrimSpace(d))
+	}
+}
+
+// NextUpdate returns when f should be fetched again. The feed's TTL extends
+// interval unless ignoreTTL is set, and the hours and days the publisher
+// asks to skip are passed over. Both are given in GMT.
+func (f *Feed) NextUpdate(interval time.Duration, ignoreTTL bool) time.Time {
+	if ttl := time.Duration(f.TTL) * time.Minute; !ignoreTTL && ttl > interval {
+		interval = ttl
+	}
+
+	// A failed fetch waits for the interval too, rather than being retried
+	// at once.
+	last := f.LastFetched
+	if f.LastErrorAt.After(last) {
+		last = f.LastErrorAt
+	}
+	next := last.Add(interval)
+	for i := 0; i < 24*7 && f.isSkipped(next); i++ {
+		next = next.UTC().Truncate(time.Hour).Add(time.Hour)
+	}
+	return next
+}
+
+func (f *Feed) isSkipped(t time.Time) bool {
+	t = t.UTC()
+	for _, h := range f.SkipHours {
+		// Hours are 0-23, though some publishers use 24 for midnight.
+		if h%24 == t.Hour() {
+			return true
+		}
+	}
+	for _, d := range f.SkipDays {
+		if strings.EqualFold(d, t.Weekday().String()) {
+			return true
+		}
+	}
+	return false
+}
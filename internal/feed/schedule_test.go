@@ -0,0 +1,30 @@
+package feed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextUpdate(t *testing.T) {
+	last := time.Date(2023, 3, 10, 10, 0, 0, 0, time.UTC) // Friday
+	f := &Feed{LastFetched: last, TTL: 60}
+
+	if got := f.NextUpdate(30*time.Minute, false); !got.Equal(last.Add(time.Hour)) {
+		t.Errorf("ttl should extend the interval, got %v", got)
+	}
+	if got := f.NextUpdate(30*time.Minute, true); !got.Equal(last.Add(30 * time.Minute)) {
+		t.Errorf("ttl should be ignored, got %v", got)
+	}
+
+	f.SkipHours = []int{11, 12}
+	if got := f.NextUpdate(time.Hour, false); !got.Equal(last.Add(3 * time.Hour)) {
+		t.Errorf("skipHours should be passed over, got %v", got)
+	}
+
+	f.SkipHours = nil
+	f.SkipDays = []string{"Saturday", "Sunday"}
+	want := time.Date(2023, 3, 13, 0, 0, 0, 0, time.UTC)
+	if got := f.NextUpdate(24*time.Hour, false); !got.Equal(want) {
+		t.Errorf("skipDays should be passed over, got %v, want %v", got, want)
+	}
+}
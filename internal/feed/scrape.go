@@ -0,0 +1,156 @@
+package feed
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// ScrapeRules build the items of a feed from a web page which has none. Item
+// selects the element of each item, and the other selectors are matched
+// within it.
+type ScrapeRules struct {
+	Item string
+	// Title defaults to the text of the link.
+	Title string
+	// Link selects an element with href, and defaults to the first link.
+	Link string
+	// Date selects the date of the item, which is read from the datetime
+	// attribute if it has one and from its text otherwise. It defaults to
+	// the first <time datetime>. Items without a date are dated when they
+	// are first seen.
+	Date        string
+	DateLayouts []string
+	Summary     string
+}
+
+// defaultDateLayouts are tried after the layouts of the rules.
+var defaultDateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04",
+	"2006/01/02",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// Validate reports a missing item selector or a selector which does not
+// compile, which would otherwise just match nothing.
+func (r *ScrapeRules) Validate() error {
+	if r.Item == "" {
+		return errors.New("no item selector")
+	}
+	for _, sel := range []string{r.Item, r.Title, r.Link, r.Date, r.Summary} {
+		if sel == "" {
+			continue
+		}
+		if _, err := cascadia.Compile(sel); err != nil {
+			return errors.Wrapf(err, "selector %q", sel)
+		}
+	}
+	return nil
+}
+
+// scrape builds a feed from the web page at pageURL with r.
+func scrape(body []byte, pageURL string, r *ScrapeRules) (*gofeed.Feed, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := base.Parse(href); err == nil {
+			base = u
+		}
+	}
+
+	feed := &gofeed.Feed{
+		Title:    strings.TrimSpace(doc.Find("title").First().Text()),
+		Link:     pageURL,
+		FeedType: "html",
+	}
+	now := time.Now()
+	doc.Find(r.Item).Each(func(_ int, s *goquery.Selection) {
+		item := &gofeed.Item{}
+
+		link := s.Filter("a[href]")
+		if r.Link != "" {
+			link = s.Find(r.Link).First()
+		} else if link.Length() == 0 {
+			link = s.Find("a[href]").First()
+		}
+		if href, ok := link.Attr("href"); ok {
+			if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+				item.Link = u.String()
+			}
+		}
+
+		title := link
+		if r.Title != "" {
+			title = s.Find(r.Title).First()
+		}
+		item.Title = collapseSpace(title.Text())
+
+		if r.Summary != "" {
+			item.Description, _ = s.Find(r.Summary).First().Html()
+		}
+
+		date := s.Find("time[datetime]").First()
+		if r.Date != "" {
+			date = s.Find(r.Date).First()
+		}
+		text := date.AttrOr("datetime", date.Text())
+		if t, ok := parseDate(strings.TrimSpace(text), r.DateLayouts); ok {
+			item.PublishedParsed = &t
+		} else {
+			// Keep the order of the page, which is usually newest first.
+			t := now.Add(-time.Duration(len(feed.Items)) * time.Second)
+			item.PublishedParsed = &t
+			item.Custom = map[string]string{customDateUnknown: "true"}
+		}
+
+		if item.Title == "" && item.Link == "" {
+			return
+		}
+		feed.Items = append(feed.Items, item)
+	})
+
+	if len(feed.Items) == 0 {
+		return nil, errors.Errorf("no items match %q", r.Item)
+	}
+	return feed, nil
+}
+
+func parseDate(s string, layouts []string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range append(append([]string{}, layouts...), defaultDateLayouts...) {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
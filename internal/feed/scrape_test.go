@@ -0,0 +1,45 @@
+package feed
+
+import (
+	"testing"
+	"time"
+)
+
+const testPage = `<html><head><title>News</title><base href="/news/"></head><body>
+<div class="post"><h2><a href="first.html">First  post</a></h2><span class="date">18.10.2026</span><p class="lead">Hello <b>world</b></p></div>
+<div class="post"><h2><a href="https://other.example/second">Second</a></h2><time datetime="2026-10-17T09:00:00Z">yesterday</time></div>
+<div class="post"><h2>No link</h2></div>
+<div class="post"></div>
+</body></html>`
+
+func TestScrape(t *testing.T) {
+	rules := &ScrapeRules{Item: "div.post", Title: "h2", Date: ".date, time", DateLayouts: []string{"02.01.2006"}, Summary: ".lead"}
+	f, err := scrape([]byte(testPage), "https://example.com/index.html", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "News" || len(f.Items) != 3 {
+		t.Fatalf("unexpected feed %q with %d items", f.Title, len(f.Items))
+	}
+
+	first := f.Items[0]
+	if first.Title != "First post" || first.Link != "https://example.com/news/first.html" || first.Description != "Hello <b>world</b>" {
+		t.Errorf("unexpected item: %q %q %q", first.Title, first.Link, first.Description)
+	}
+	if want := time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local); !first.PublishedParsed.Equal(want) {
+		t.Errorf("date = %v, want %v", first.PublishedParsed, want)
+	}
+	if second := f.Items[1]; second.PublishedParsed.Year() != 2026 || second.Link != "https://other.example/second" {
+		t.Errorf("unexpected item: %v %q", second.PublishedParsed, second.Link)
+	}
+	if third := f.Items[2]; third.Custom[customDateUnknown] == "" {
+		t.Errorf("an item without a date should be marked")
+	}
+
+	if _, err := scrape([]byte(testPage), "https://example.com/", &ScrapeRules{Item: "li"}); err == nil {
+		t.Error("rules which match nothing should fail")
+	}
+	if err := (&ScrapeRules{Item: "div["}).Validate(); err == nil {
+		t.Error("an invalid selector should be reported")
+	}
+}
@@ -0,0 +1,108 @@
+package feed
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Shorthand expands links like "gh:owner/repo" into the URL of a feed. The
+// link keeps the shorthand, which is expanded whenever the feed is fetched.
+//
+// A shorthand applies to links starting with Name and a colon. The rest of
+// the link must match Pattern, which defaults to anything, and replaces "{0}"
+// in URL, while the submatches of Pattern replace "{1}", "{2}" and so on.
+type Shorthand struct {
+	Name    string
+	Pattern string
+	URL     string
+}
+
+// DefaultShorthands are tried after those of the user. Several shorthands
+// with the same name are tried in order.
+var DefaultShorthands = []*Shorthand{
+	{Name: "gh", Pattern: `^([\w.-]+)/([\w.-]+)$`, URL: "https://github.com/{1}/{2}/releases.atom"},
+	{Name: "gh", Pattern: `^([\w.-]+)/([\w.-]+)/(releases|commits|tags)$`, URL: "https://github.com/{1}/{2}/{3}.atom"},
+	{Name: "gh", Pattern: `^([\w.-]+)/([\w.-]+)/commits/(.+)$`, URL: "https://github.com/{1}/{2}/commits/{3}.atom"},
+	{Name: "reddit", Pattern: `^(?:r/)?(\w+)$`, URL: "https://www.reddit.com/r/{1}/.rss"},
+	{Name: "yt", Pattern: `^(UC[\w-]{22})$`, URL: "https://www.youtube.com/feeds/videos.xml?channel_id={1}"},
+	{Name: "yt", Pattern: `^((?:PL|UU|OL)[\w-]+)$`, URL: "https://www.youtube.com/feeds/videos.xml?playlist_id={1}"},
+	{Name: "mastodon", Pattern: `^@?([\w.]+)@([\w.-]+)$`, URL: "https://{2}/@{1}.rss"},
+}
+
+var (
+	shorthandName        = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	shorthandPlaceholder = regexp.MustCompile(`\{(\d+)\}`)
+)
+
+// reservedNames are the prefixes of the other kinds of links.
+var reservedNames = []string{"http", "https", "file", "gemini", "gopher", "exec", "git", "mail"}
+
+// Validate reports a name which cannot be told from other links, a pattern
+// which does not compile, or a URL without a placeholder.
+func (s *Shorthand) Validate() error {
+	if !shorthandName.MatchString(s.Name) || contains(reservedNames, s.Name) {
+		return errors.Errorf("invalid name %q", s.Name)
+	}
+	if _, err := s.compile(); err != nil {
+		return errors.Wrapf(err, "pattern %q", s.Pattern)
+	}
+	if !isUrl(shorthandPlaceholder.ReplaceAllString(s.URL, "x")) {
+		return errors.Errorf("%q is not a URL", s.URL)
+	}
+	return nil
+}
+
+func (s *Shorthand) compile() (*regexp.Regexp, error) {
+	if s.Pattern == "" {
+		return regexp.Compile(`^(.+)$`)
+	}
+	return regexp.Compile(s.Pattern)
+}
+
+// expand returns the URL for arg, the link without the name.
+func (s *Shorthand) expand(arg string) (string, bool) {
+	re, err := s.compile()
+	if err != nil {
+		return "", false
+	}
+	m := re.FindStringSubmatch(arg)
+	if m == nil {
+		return "", false
+	}
+	return shorthandPlaceholder.ReplaceAllStringFunc(s.URL, func(p string) string {
+		i, _ := strconv.Atoi(p[1 : len(p)-1])
+		if i == 0 {
+			return arg
+		}
+		if i < len(m) {
+			return m[i]
+		}
+		return ""
+	}), true
+}
+
+// ExpandShorthand returns the URL of link if it is a shorthand of rules or
+// of DefaultShorthands, and link itself otherwise.
+func ExpandShorthand(link string, rules []*Shorthand) (string, error) {
+	name, arg, ok := strings.Cut(link, ":")
+	if !ok || strings.HasPrefix(arg, "//") {
+		return link, nil
+	}
+	isKnown := false
+	for _, s := range append(append([]*Shorthand{}, rules...), DefaultShorthands...) {
+		if s.Name != name {
+			continue
+		}
+		isKnown = true
+		if u, ok := s.expand(arg); ok {
+			return u, nil
+		}
+	}
+	if isKnown {
+		return "", errors.Errorf("%s is not a valid %s: shorthand", link, name)
+	}
+	return link, nil
+}
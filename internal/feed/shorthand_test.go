@@ -0,0 +1,65 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpandShorthand(t *testing.T) {
+	user := []*Shorthand{
+		{Name: "lobsters", URL: "https://lobste.rs/t/{0}.rss"},
+		{Name: "reddit", Pattern: `^top/(\w+)$`, URL: "https://www.reddit.com/r/{1}/top/.rss"},
+	}
+	for link, want := range map[string]string{
+		"gh:golang/go":                     "https://github.com/golang/go/releases.atom",
+		"gh:golang/go/commits":             "https://github.com/golang/go/commits.atom",
+		"gh:golang/go/commits/release-1.2": "https://github.com/golang/go/commits/release-1.2.atom",
+		"reddit:r/golang":                  "https://www.reddit.com/r/golang/.rss",
+		"reddit:top/golang":                "https://www.reddit.com/r/golang/top/.rss",
+		"yt:UC_x5XG1OV2P6uZZ5FSM9Ttw":      "https://www.youtube.com/feeds/videos.xml?channel_id=UC_x5XG1OV2P6uZZ5FSM9Ttw",
+		"mastodon:@alice@example.social":   "https://example.social/@alice.rss",
+		"lobsters:go":                      "https://lobste.rs/t/go.rss",
+		"https://example.com/feed":         "https://example.com/feed",
+		"exec:echo":                        "exec:echo",
+	} {
+		got, err := ExpandShorthand(link, user)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", link, got, err, want)
+		}
+	}
+	if _, err := ExpandShorthand("gh:golang", user); err == nil {
+		t.Error("expected an error for an invalid shorthand")
+	}
+
+	for _, s := range []*Shorthand{
+		{Name: "exec", URL: "https://example.com/{0}"},
+		{Name: "x", Pattern: "(", URL: "https://example.com/{0}"},
+		{Name: "x", URL: "{0}"},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v should be invalid", s)
+		}
+	}
+}
+
+func TestShorthandFeed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old/golang" {
+			http.Redirect(w, r, "/feeds/golang", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte(testRSS))
+	}))
+	defer srv.Close()
+
+	opts := &Options{Shorthands: []*Shorthand{{Name: "test", URL: srv.URL + "/old/{0}"}}}
+	f, fetch, err := GetFeedFromURL(context.Background(), "test:golang", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.FeedLink != "test:golang" || f.Items[0].Belong != "test:golang" || fetch.MovedTo != "" {
+		t.Errorf("the shorthand was not kept: %q, moved to %q", f.FeedLink, fetch.MovedTo)
+	}
+}
@@ -0,0 +1,277 @@
+package feed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+)
+
+// SitemapRules narrow down the pages of a sitemap which become items. A page
+// must match one of Include, if there are any, and none of Exclude. Both are
+// regular expressions matched against the URL of the page.
+type SitemapRules struct {
+	Include []string
+	Exclude []string
+}
+
+const (
+	// maxSitemapItems is how many pages are kept per fetch, the most
+	// recently modified first.
+	maxSitemapItems = 500
+	// maxChildSitemaps is how many sitemaps of an index are read per fetch,
+	// the most recently modified first.
+	maxChildSitemaps = 20
+	// maxSitemapDepth is how deep indexes of indexes are followed.
+	maxSitemapDepth = 2
+)
+
+// Validate reports a pattern which does not compile.
+func (r *SitemapRules) Validate() error {
+	_, _, err := r.compile()
+	return err
+}
+
+func (r *SitemapRules) compile() (include, exclude []*regexp.Regexp, err error) {
+	if r == nil {
+		return nil, nil, nil
+	}
+	for _, p := range r.Include {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "include %q", p)
+		}
+		include = append(include, re)
+	}
+	for _, p := range r.Exclude {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "exclude %q", p)
+		}
+		exclude = append(exclude, re)
+	}
+	return include, exclude, nil
+}
+
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []*sitemapEntry `xml:"url"`
+	Sitemaps []*sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+	lastMod *time.Time
+}
+
+// sitemapLayouts are the W3C datetime formats of <lastmod>.
+var sitemapLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// isSitemap reports whether body is a sitemap or a sitemap index, possibly
+// gzipped.
+func isSitemap(body []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(gunzip(body)))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return false
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local == "urlset" || se.Name.Local == "sitemapindex"
+		}
+	}
+}
+
+// gunzip returns body decompressed if it is gzipped, as sitemaps often are,
+// and body itself otherwise.
+func gunzip(body []byte) []byte {
+	if !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		return body
+	}
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return body
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// readSitemap makes a feed of the pages in the sitemap fetched from link,
+// fetching the sitemaps of an index with the HTTP options of opts.
+func readSitemap(ctx context.Context, link string, body []byte, opts *Options) (*gofeed.Feed, error) {
+	include, exclude, err := opts.sitemap().compile()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := sitemapPages(ctx, link, body, opts.http(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	title := link
+	if u, err := url.Parse(link); err == nil && u.Host != "" {
+		title = u.Host
+	}
+	feed := &gofeed.Feed{Title: title, Link: link, FeedType: "sitemap"}
+	now := time.Now()
+	isAdded := map[string]bool{}
+	for _, e := range entries {
+		if !matchesAny(include, e.Loc, true) || matchesAny(exclude, e.Loc, false) {
+			continue
+		}
+		item := &gofeed.Item{
+			GUID:  e.Loc,
+			Link:  e.Loc,
+			Title: pageTitle(e.Loc),
+		}
+		if e.lastMod != nil {
+			// A page modified again comes back as a new item.
+			item.GUID += "#" + e.lastMod.UTC().Format(time.RFC3339)
+			item.PublishedParsed = e.lastMod
+		} else {
+			t := now.Add(-time.Duration(len(feed.Items)) * time.Second)
+			item.PublishedParsed = &t
+			item.Custom = map[string]string{customDateUnknown: "true"}
+		}
+		if isAdded[item.GUID] {
+			continue
+		}
+		isAdded[item.GUID] = true
+		feed.Items = append(feed.Items, item)
+		if len(feed.Items) >= maxSitemapItems {
+			break
+		}
+	}
+	return feed, nil
+}
+
+// sitemapPages returns the pages of a sitemap, or of the sitemaps listed in
+// an index, the most recently modified first. link is the feed, whose opts
+// are only sent to sitemaps on its own host.
+func sitemapPages(ctx context.Context, link string, body []byte, opts *HTTPOptions, depth int) ([]*sitemapEntry, error) {
+	doc := &sitemapDoc{}
+	if err := xml.Unmarshal(gunzip(body), doc); err != nil {
+		return nil, err
+	}
+	switch doc.XMLName.Local {
+	case "urlset":
+		return sortEntries(doc.URLs), nil
+	case "sitemapindex":
+	default:
+		return nil, errors.Errorf("<%s> is not a sitemap", doc.XMLName.Local)
+	}
+	if depth >= maxSitemapDepth {
+		return nil, nil
+	}
+
+	children := sortEntries(doc.Sitemaps)
+	if len(children) > maxChildSitemaps {
+		children = children[:maxChildSitemaps]
+	}
+	pages := []*sitemapEntry{}
+	for _, child := range children {
+		childOpts := opts
+		if !isSameHost(child.Loc, link) {
+			childOpts = nil
+		}
+		resp, err := fetchURL(ctx, child.Loc, "", "", childOpts)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, child.Loc)
+		}
+		childPages, err := sitemapPages(ctx, link, resp.Body, opts, depth+1)
+		if err != nil {
+			return nil, errors.Wrap(err, child.Loc)
+		}
+		pages = append(pages, childPages...)
+	}
+	return sortEntries(pages), nil
+}
+
+// isSameHost reports whether a and b are URLs of the same host and port.
+func isSameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// sortEntries parses the dates of entries and sorts them newest first,
+// keeping the order of those without a date after the rest.
+func sortEntries(entries []*sitemapEntry) []*sitemapEntry {
+	valid := []*sitemapEntry{}
+	for _, e := range entries {
+		e.Loc = strings.TrimSpace(e.Loc)
+		if e.Loc == "" {
+			continue
+		}
+		if e.lastMod == nil {
+			if t, ok := parseDate(strings.TrimSpace(e.LastMod), sitemapLayouts); ok {
+				e.lastMod = &t
+			}
+		}
+		valid = append(valid, e)
+	}
+	sort.SliceStable(valid, func(i, j int) bool {
+		a, b := valid[i].lastMod, valid[j].lastMod
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.After(*b)
+	})
+	return valid
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string, ifNone bool) bool {
+	if len(patterns) == 0 {
+		return ifNone
+	}
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageTitle names a page by its path, since sitemaps have no titles.
+func pageTitle(loc string) string {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return loc
+	}
+	path := strings.Trim(u.Path, "/")
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+	if path == "" {
+		return u.Host
+	}
+	return path
+}
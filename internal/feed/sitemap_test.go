@@ -0,0 +1,118 @@
+package feed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSitemap(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%[1]s/docs.xml</loc><lastmod>2026-10-02</lastmod></sitemap>
+  <sitemap><loc>%[1]s/blog.xml.gz</loc></sitemap>
+</sitemapindex>`, srv.URL)
+		case "/docs.xml":
+			fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%[1]s/docs/install</loc><lastmod>2026-10-01T09:00:00+00:00</lastmod></url>
+  <url><loc>%[1]s/docs/api%%20guide</loc><lastmod>2026-10-03</lastmod></url>
+  <url><loc>%[1]s/docs/tags/go</loc><lastmod>2026-10-04</lastmod></url>
+</urlset>`, srv.URL)
+		case "/blog.xml.gz":
+			gz := gzip.NewWriter(w)
+			fmt.Fprintf(gz, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%[1]s/blog/hello</loc></url>
+</urlset>`, srv.URL)
+			gz.Close()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	opts := &Options{Sitemap: &SitemapRules{Exclude: []string{`/tags/`}}}
+	f, _, err := GetFeedFromURL(context.Background(), srv.URL+"/sitemap.xml", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, item := range f.Items {
+		titles = append(titles, item.Title)
+	}
+	// The undated page is dated now, and comes first.
+	if fmt.Sprint(titles) != "[blog/hello docs/api guide docs/install]" {
+		t.Fatalf("unexpected items: %v", titles)
+	}
+	// A page modified again is a new item.
+	if install := f.Items[2]; install.GUID != srv.URL+"/docs/install#2026-10-01T09:00:00Z" || install.Link != srv.URL+"/docs/install" {
+		t.Errorf("unexpected item: %+v", install)
+	}
+
+	// A sitemap is recognized without rules too.
+	if f, _, err := GetFeedFromURL(context.Background(), srv.URL+"/docs.xml", 1, nil); err != nil || len(f.Items) != 3 {
+		t.Errorf("unexpected result: %v", err)
+	}
+	opts = &Options{Sitemap: &SitemapRules{Include: []string{`/docs/`}}}
+	if f, _, err := GetFeedFromURL(context.Background(), srv.URL+"/blog.xml.gz", 1, opts); err != nil || len(f.Items) != 0 {
+		t.Errorf("unexpected result: %v", err)
+	}
+
+	if !isSitemap([]byte(`<?xml version="1.0"?><urlset/>`)) || isSitemap([]byte(`<rss version="2.0"/>`)) || isSitemap(bytes.Repeat([]byte("x"), 10)) {
+		t.Error("isSitemap is wrong")
+	}
+}
+
+func TestSitemapIndexKeepsCredentials(t *testing.T) {
+	t.Setenv("FEED_TEST_TOKEN", "s3cret")
+	var other *http.Request
+	otherSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other = r
+		fmt.Fprint(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>https://example.org/page</loc></url></urlset>`)
+	}))
+	defer otherSrv.Close()
+	var own *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%s/other.xml</loc></sitemap>
+  <sitemap><loc>http://%s/own.xml</loc></sitemap>
+</sitemapindex>`, otherSrv.URL, r.Host)
+		case "/own.xml":
+			own = r
+			fmt.Fprint(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>https://example.com/page</loc></url></urlset>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	opts := &Options{
+		HTTP: &HTTPOptions{
+			Header:      map[string]string{"X-Api-Key": "env:FEED_TEST_TOKEN"},
+			BearerToken: "env:FEED_TEST_TOKEN",
+		},
+		Sitemap: &SitemapRules{},
+	}
+	f, _, err := GetFeedFromURL(context.Background(), srv.URL+"/sitemap.xml", 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Items) != 2 || other == nil || own == nil {
+		t.Fatalf("unexpected items: %+v", f.Items)
+	}
+	if other.Header.Get("Authorization") != "" || other.Header.Get("X-Api-Key") != "" {
+		t.Errorf("credentials were sent to another host: %v", other.Header)
+	}
+	if own.Header.Get("Authorization") == "" || own.Header.Get("X-Api-Key") != "s3cret" {
+		t.Errorf("credentials were not sent to the host of the feed: %v", own.Header)
+	}
+}
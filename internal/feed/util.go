@@ -1,6 +1,9 @@
 package feed
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 func FormatDate(t *time.Time) string {
 	if t == nil {
@@ -17,3 +20,13 @@ func FormatTime(t *time.Time) string {
 	const format = "15:04"
 	return t.Format(format)
 }
+
+func FormatSize(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,167 @@
+package opml
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Namespace qualifies the attributes rssviewer adds to outlines. Other
+// readers ignore them.
+const (
+	Namespace = "https://github.com/yitose/rssviewer"
+	prefix    = "rssviewer"
+)
+
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	XMLNS   string   `xml:"xmlns:rssviewer,attr,omitempty"`
+	Head    Head     `xml:"head"`
+	Body    Body     `xml:"body"`
+}
+
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type Body struct {
+	Outlines []*Outline `xml:"outline"`
+}
+
+// Outline is either a feed, when XMLURL or Command is set, or a category
+// containing other outlines.
+type Outline struct {
+	Text    string
+	Title   string
+	Type    string
+	XMLURL  string
+	HTMLURL string
+	Color   int
+	Command string
+	// Scrape, JSON and Sitemap hold the rules which make a feed of a page,
+	// encoded as JSON.
+	Scrape   string
+	JSON     string
+	Sitemap  string
+	Outlines []*Outline
+}
+
+func New(title string) *OPML {
+	return &OPML{
+		Version: "2.0",
+		XMLNS:   Namespace,
+		Head:    Head{Title: title},
+	}
+}
+
+func Parse(r io.Reader) (*OPML, error) {
+	var o OPML
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := d.Decode(&o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (o *OPML) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(o); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func (o *Outline) IsFeed() bool {
+	return o.XMLURL != "" || o.Command != ""
+}
+
+// Name returns the title of the outline, falling back to its text.
+func (o *Outline) Name() string {
+	if o.Title != "" {
+		return o.Title
+	}
+	return o.Text
+}
+
+// UnmarshalXML reads attribute names case-insensitively, since readers
+// disagree on spellings such as "xmlUrl" and "xmlurl".
+func (o *Outline) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, a := range start.Attr {
+		custom := a.Name.Space == Namespace || a.Name.Space == prefix
+		switch name := strings.ToLower(a.Name.Local); {
+		case custom && name == "color":
+			if c, err := strconv.Atoi(a.Value); err == nil {
+				o.Color = c
+			}
+		case custom && name == "command":
+			o.Command = a.Value
+		case custom && name == "scrape":
+			o.Scrape = a.Value
+		case custom && name == "json":
+			o.JSON = a.Value
+		case custom && name == "sitemap":
+			o.Sitemap = a.Value
+		case name == "text":
+			o.Text = a.Value
+		case name == "title":
+			o.Title = a.Value
+		case name == "type":
+			o.Type = a.Value
+		case name == "xmlurl":
+			o.XMLURL = a.Value
+		case name == "htmlurl":
+			o.HTMLURL = a.Value
+		}
+	}
+
+	var children struct {
+		Outlines []*Outline `xml:"outline"`
+	}
+	if err := d.DecodeElement(&children, &start); err != nil {
+		return err
+	}
+	o.Outlines = children.Outlines
+	return nil
+}
+
+func (o *Outline) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	attr := func(name, value string) {
+		if value != "" {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+		}
+	}
+	attr("text", o.Text)
+	attr("title", o.Title)
+	attr("type", o.Type)
+	attr("xmlUrl", o.XMLURL)
+	attr("htmlUrl", o.HTMLURL)
+	if o.Color != 0 {
+		attr(prefix+":color", strconv.Itoa(o.Color))
+	}
+	attr(prefix+":command", o.Command)
+	attr(prefix+":scrape", o.Scrape)
+	attr(prefix+":json", o.JSON)
+	attr(prefix+":sitemap", o.Sitemap)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, child := range o.Outlines {
+		if err := e.EncodeElement(child, xml.StartElement{Name: xml.Name{Local: "outline"}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,65 @@
+package opml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	o := New("test")
+	o.Body.Outlines = []*Outline{
+		{Text: "Go", Outlines: []*Outline{
+			{Text: "Blog", Type: "rss", XMLURL: "https://go.dev/blog/feed.atom", Color: 42},
+		}},
+		{Text: "Local", Type: "rss", Command: "cat feed.xml"},
+		{Text: "Page", Type: "rss", XMLURL: "https://example.com/news", Scrape: `{"item":"article"}`},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := o.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Body.Outlines) != 3 {
+		t.Fatalf("outlines = %d, want 3", len(parsed.Body.Outlines))
+	}
+	feed := parsed.Body.Outlines[0].Outlines[0]
+	if feed.XMLURL != "https://go.dev/blog/feed.atom" || feed.Color != 42 {
+		t.Errorf("unexpected feed outline: %+v", feed)
+	}
+	if cmd := parsed.Body.Outlines[1]; cmd.Command != "cat feed.xml" || !cmd.IsFeed() {
+		t.Errorf("unexpected command outline: %+v", cmd)
+	}
+	if page := parsed.Body.Outlines[2]; page.Scrape != `{"item":"article"}` {
+		t.Errorf("unexpected scraped outline: %+v", page)
+	}
+}
+
+func TestParseForeign(t *testing.T) {
+	const src = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<opml version="1.0">
+<head><title>Subscriptions</title></head>
+<body>
+<outline title="News" text="News">
+<outline text="Example" title="Example" type="rss" xmlurl="https://example.com/rss" htmlurl="https://example.com/"/>
+</outline>
+</body>
+</opml>`
+
+	o, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := o.Body.Outlines[0]
+	if group.IsFeed() || group.Name() != "News" {
+		t.Errorf("unexpected category outline: %+v", group)
+	}
+	if f := group.Outlines[0]; f.XMLURL != "https://example.com/rss" || f.Name() != "Example" {
+		t.Errorf("unexpected feed outline: %+v", f)
+	}
+}
@@ -0,0 +1,215 @@
+package refresh
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	fd "github.com/yitose/rssviewer/internal/feed"
+)
+
+// Engine fetches feeds concurrently. Jobs for the same host are run one at a
+// time with HostDelay between them, so that a server with many feeds is not
+// hit by several requests at once.
+type Engine struct {
+	// Concurrency is the number of hosts fetched from at the same time.
+	Concurrency int
+	HostDelay   time.Duration
+	// Timeout bounds each fetch. 0 means no timeout.
+	Timeout time.Duration
+}
+
+type Job struct {
+	Link string
+	// URL is where Link is fetched from, which differs for shorthands like
+	// "gh:owner/repo". Jobs are queued by its host.
+	URL   string
+	Fetch func(ctx context.Context) (*fd.Feed, *fd.Fetch, error)
+}
+
+type Result struct {
+	Job   *Job
+	Feed  *fd.Feed
+	Fetch *fd.Fetch
+	Err   error
+}
+
+// UpdateJob refreshes f. f must not be changed until the job is done.
+func UpdateJob(f *fd.Feed, opts *fd.Options) *Job {
+	return &Job{
+		Link: f.FeedLink,
+		URL:  expandLink(f.FeedLink, opts),
+		Fetch: func(ctx context.Context) (*fd.Feed, *fd.Fetch, error) {
+			return fd.UpdateFeed(ctx, f, opts)
+		},
+	}
+}
+
+// NewFeedJob fetches a feed which is not subscribed yet. title is used if
+// the feed has none. The fetch starts the history of the new feed.
+//
+// If link is a web page rather than a feed, the feed it links to is fetched
+// instead. A page with several feeds fails with *fd.MultipleFeedsError.
+func NewFeedJob(link, title string, color int, opts *fd.Options) *Job {
+	u := expandLink(link, opts)
+	return &Job{
+		Link: link,
+		URL:  u,
+		Fetch: func(ctx context.Context) (*fd.Feed, *fd.Fetch, error) {
+			f, fetch, err := fd.GetFeedFromURL(ctx, link, color, opts)
+			if err != nil && fetch.Status/100 == 2 && !opts.IsMapped() {
+				f, fetch, err = discoverFeed(ctx, u, color, opts, fetch, err)
+			}
+			if err != nil {
+				return nil, fetch, err
+			}
+			if f.Title == "" {
+				f.Title = title
+			}
+			f.AddFetch(fetch)
+			return f, fetch, nil
+		},
+	}
+}
+
+// expandLink returns the URL of link if it is a shorthand. A shorthand which
+// does not expand is left for the fetch to report.
+func expandLink(link string, opts *fd.Options) string {
+	var rules []*fd.Shorthand
+	if opts != nil {
+		rules = opts.Shorthands
+	}
+	if u, err := fd.ExpandShorthand(link, rules); err == nil {
+		return u
+	}
+	return link
+}
+
+// discoverFeed fetches the feed linked from the web page at link, which must
+// be expanded already. fetch and err are those of fetching the page itself,
+// and are returned if the page has no feed.
+func discoverFeed(ctx context.Context, link string, color int, opts *fd.Options, fetch *fd.Fetch, err error) (*fd.Feed, *fd.Fetch, error) {
+	var httpOpts *fd.HTTPOptions
+	if opts != nil {
+		httpOpts = opts.HTTP
+	}
+	var page *fd.NotFeedError
+	errors.As(err, &page)
+	candidates, discoverErr := fd.Discover(ctx, link, page, httpOpts)
+	switch {
+	case discoverErr != nil || len(candidates) == 0:
+		return nil, fetch, err
+	case len(candidates) > 1:
+		return nil, fetch, &fd.MultipleFeedsError{URL: link, Candidates: candidates}
+	}
+	return fd.GetFeedFromURL(ctx, candidates[0].URL, color, opts)
+}
+
+// Run runs jobs and calls handle with each result as it comes in. handle is
+// called from the goroutine of Run, one result at a time. Run returns when
+// every job is done or ctx is cancelled; jobs which have not started by then
+// are dropped without a result.
+func (e *Engine) Run(ctx context.Context, jobs []*Job, handle func(*Result)) {
+	queues := [][]*Job{}
+	index := map[string]int{}
+	for _, job := range jobs {
+		link := job.URL
+		if link == "" {
+			link = job.Link
+		}
+		host := hostOf(link)
+		i, ok := index[host]
+		if !ok {
+			i = len(queues)
+			index[host] = i
+			queues = append(queues, nil)
+		}
+		queues[i] = append(queues[i], job)
+	}
+
+	workers := e.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(queues) {
+		workers = len(queues)
+	}
+
+	queueCh := make(chan []*Job)
+	results := make(chan *Result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for queue := range queueCh {
+				e.runQueue(ctx, queue, results)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(queueCh)
+		for _, queue := range queues {
+			select {
+			case queueCh <- queue:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		handle(r)
+	}
+}
+
+// runQueue runs the jobs for a single host in order.
+func (e *Engine) runQueue(ctx context.Context, queue []*Job, results chan<- *Result) {
+	for i, job := range queue {
+		if i > 0 && e.HostDelay > 0 {
+			select {
+			case <-time.After(e.HostDelay):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		feed, fetch, err := e.fetch(ctx, job)
+		if ctx.Err() != nil {
+			// A fetch cut short by cancellation says nothing about the feed.
+			return
+		}
+		results <- &Result{Job: job, Feed: feed, Fetch: fetch, Err: err}
+	}
+}
+
+func (e *Engine) fetch(ctx context.Context, job *Job) (*fd.Feed, *fd.Fetch, error) {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	return job.Fetch(ctx)
+}
+
+// hostOf returns the key by which the jobs for link are serialized. Commands
+// are keyed by themselves, since they do not share a server as far as we know.
+func hostOf(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+	return strings.ToLower(u.Hostname())
+}
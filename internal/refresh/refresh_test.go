@@ -0,0 +1,128 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	fd "github.com/yitose/rssviewer/internal/feed"
+)
+
+func TestRunLimitsConcurrency(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		running = map[string]int{}
+		total   int
+		maxSeen int
+	)
+	fetch := func(host string) func(context.Context) (*fd.Feed, *fd.Fetch, error) {
+		return func(ctx context.Context) (*fd.Feed, *fd.Fetch, error) {
+			mu.Lock()
+			running[host]++
+			total++
+			if running[host] > 1 {
+				t.Errorf("%s was fetched from twice at once", host)
+			}
+			if total > maxSeen {
+				maxSeen = total
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			running[host]--
+			total--
+			mu.Unlock()
+			return &fd.Feed{}, &fd.Fetch{}, nil
+		}
+	}
+
+	jobs := []*Job{}
+	for h := 0; h < 4; h++ {
+		for i := 0; i < 3; i++ {
+			host := fmt.Sprintf("host%d.example.com", h)
+			jobs = append(jobs, &Job{Link: fmt.Sprintf("https://%s/%d", host, i), Fetch: fetch(host)})
+		}
+	}
+
+	e := &Engine{Concurrency: 2}
+	n := 0
+	e.Run(context.Background(), jobs, func(r *Result) { n++ })
+
+	if n != len(jobs) {
+		t.Errorf("got %d results, want %d", n, len(jobs))
+	}
+	if maxSeen > 2 {
+		t.Errorf("%d fetches ran at once, want at most 2", maxSeen)
+	}
+}
+
+func TestRunTimeoutAndCancel(t *testing.T) {
+	slow := func(ctx context.Context) (*fd.Feed, *fd.Fetch, error) {
+		<-ctx.Done()
+		return nil, &fd.Fetch{Error: ctx.Err().Error()}, ctx.Err()
+	}
+
+	e := &Engine{Concurrency: 1, Timeout: 10 * time.Millisecond}
+	var results []*Result
+	e.Run(context.Background(), []*Job{{Link: "https://example.com/", Fetch: slow}}, func(r *Result) {
+		results = append(results, r)
+	})
+	if len(results) != 1 || results[0].Err != context.DeadlineExceeded {
+		t.Errorf("a fetch which times out should fail: %+v", results)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.Run(ctx, []*Job{{Link: "https://example.com/", Fetch: slow}}, func(r *Result) {
+		t.Errorf("no result is expected after cancellation: %+v", r)
+	})
+}
+
+func TestShorthandJobsShareHost(t *testing.T) {
+	opts := &fd.Options{Shorthands: []*fd.Shorthand{{Name: "blog", URL: "https://blog.example.com/{0}"}}}
+	for link, want := range map[string]string{
+		"gh:golang/go":     "github.com",
+		"gh:golang/tools":  "github.com",
+		"reddit:golang":    "www.reddit.com",
+		"blog:news":        "blog.example.com",
+		"exec:echo hello":  "exec:echo hello",
+		"gh:not-a-project": "gh:not-a-project",
+	} {
+		job := NewFeedJob(link, "", 0, opts)
+		if got := hostOf(job.URL); got != want {
+			t.Errorf("%s is queued by %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestNewFeedJobFetchesPageOnce(t *testing.T) {
+	pageRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		pageRequests++
+		w.Write([]byte(`<html><head><link rel="alternate" type="application/rss+xml" href="/feed.xml"></head></html>`))
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<rss version="2.0"><channel><title>Blog</title>` +
+			`<item><title>Hello</title><guid>1</guid><pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate></item></channel></rss>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f, _, err := NewFeedJob(srv.URL+"/", "", 0, nil).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.FeedLink != srv.URL+"/feed.xml" || len(f.Items) != 1 {
+		t.Errorf("unexpected feed %q with %d items", f.FeedLink, len(f.Items))
+	}
+	if pageRequests != 1 {
+		t.Errorf("the page was requested %d times, want 1", pageRequests)
+	}
+}
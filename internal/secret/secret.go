@@ -0,0 +1,78 @@
+// Package secret resolves references to passwords and tokens, so that they
+// are kept in the environment, in a file of their own or in a password
+// manager rather than in config.json.
+package secret
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/yitose/rssviewer/pkg/util"
+)
+
+const (
+	envPrefix  = "env:"
+	filePrefix = "file:"
+	cmdPrefix  = "cmd:"
+)
+
+var (
+	mu    sync.Mutex
+	cache = map[string]string{}
+)
+
+// IsRef reports whether s refers to a secret rather than being one.
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, envPrefix) || strings.HasPrefix(s, filePrefix) || strings.HasPrefix(s, cmdPrefix)
+}
+
+// Resolve returns the secret ref refers to: "env:NAME" is an environment
+// variable, "file:PATH" the contents of a file and "cmd:COMMAND" the output
+// of a shell command, each without surrounding whitespace. Files and
+// commands are read once per process.
+func Resolve(ref string) (string, error) {
+	if name := strings.TrimPrefix(ref, envPrefix); name != ref {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if v, ok := cache[ref]; ok {
+		return v, nil
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+	switch {
+	case strings.HasPrefix(ref, filePrefix):
+		b, err = os.ReadFile(util.ExpandHome(strings.TrimPrefix(ref, filePrefix)))
+	case strings.HasPrefix(ref, cmdPrefix):
+		b, err = shell(strings.TrimPrefix(ref, cmdPrefix)).Output()
+	default:
+		return "", errors.New("a secret must be given as env:NAME, file:PATH or cmd:COMMAND")
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "reading a secret")
+	}
+
+	v := strings.TrimSpace(string(b))
+	cache[ref] = v
+	return v, nil
+}
+
+func shell(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("powershell.exe", "-Command", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
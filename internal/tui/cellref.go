@@ -2,9 +2,12 @@ package tui
 
 import fd "github.com/yitose/rssviewer/internal/feed"
 
+// Cursor and ItemKey remember the selected item, so that it stays selected
+// when the items are listed again after a refresh.
 type FeedCellRef struct {
-	Feed   *fd.Feed
-	Cursor int
+	Feed    *fd.Feed
+	Cursor  int
+	ItemKey string
 }
 
 func NewFeedCellRef(f *fd.Feed) *FeedCellRef {
@@ -15,8 +18,9 @@ func NewFeedCellRef(f *fd.Feed) *FeedCellRef {
 }
 
 type GroupCellRef struct {
-	Group  *fd.Group
-	Cursor int
+	Group   *fd.Group
+	Cursor  int
+	ItemKey string
 }
 
 func NewGroupCellRef(g *fd.Group) *GroupCellRef {
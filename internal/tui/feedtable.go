@@ -1,6 +1,8 @@
 package tui
 
 import (
+	"fmt"
+
 	"github.com/gdamore/tcell/v2"
 	"github.com/pkg/errors"
 	"github.com/rivo/tview"
@@ -20,20 +22,23 @@ func (t *FeedTable) setCell(f *fd.Feed) *tview.TableCell {
 
 	maxRow := t.GetRowCount()
 	targetRow := maxRow
+	cellRef := NewFeedCellRef(f)
 	for i := 0; i < maxRow; i++ {
 		cell := t.GetCell(i, 0)
 		ref, ok := cell.GetReference().(*FeedCellRef)
 		if ok {
 			if ref.Feed.FeedLink == f.FeedLink {
 				targetRow = i
+				cellRef = ref
+				cellRef.Feed = f
 				break
 			}
 		}
 	}
 
-	cell := tview.NewTableCell(f.Title).
+	cell := tview.NewTableCell(feedCellText(f)).
 		SetTextColor(tcell.Color(f.Color + 1<<32)).
-		SetReference(NewFeedCellRef(f))
+		SetReference(cellRef)
 
 	t.SetCell(targetRow, 0, cell)
 
@@ -44,3 +49,27 @@ func (t *FeedTable) setCell(f *fd.Feed) *tview.TableCell {
 
 	return cell
 }
+
+// staleMark is put before the title of a feed whose last fetch failed, and
+// goneMark before one which has been disabled.
+const (
+	staleMark = "! "
+	goneMark  = "x "
+)
+
+func feedCellText(f *fd.Feed) string {
+	title := f.Title
+	if f.Disabled {
+		title = goneMark + title
+	} else if f.IsStale() {
+		title = staleMark + title
+	}
+	return withUnreadCount(title, f.UnreadCount())
+}
+
+func withUnreadCount(title string, unread int) string {
+	if unread == 0 {
+		return title
+	}
+	return fmt.Sprintf("%s (%d)", title, unread)
+}
@@ -40,6 +40,12 @@ func (t *Tui) setFocusFunc() {
 		}
 		t.Help(append(help, t.commonKeyHelp()...))
 	})
+	t.HealthWidget.SetFocusFunc(func() {
+		t.highlightBox(t.HealthWidget.Box)
+	})
+	t.PickerWidget.SetFocusFunc(func() {
+		t.highlightBox(t.PickerWidget.Box)
+	})
 	t.ColorWidget.SetFocusFunc(func() {
 		t.highlightBox(t.ColorWidget.Box)
 		t.itemTableSelectionChangedFunc(t.ColorWidget.GetSelection())
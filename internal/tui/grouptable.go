@@ -13,25 +13,28 @@ type GroupTable struct {
 	*FeedTable
 }
 
-func (t *GroupTable) setCell(g *fd.Group) *tview.TableCell {
+func (t *GroupTable) setCell(g *fd.Group, unread int) *tview.TableCell {
 	if g == nil {
 		return nil
 	}
 
 	maxRow := t.GetRowCount()
 	targetRow := maxRow
+	cellRef := NewGroupCellRef(g)
 	for i := 0; i < maxRow; i++ {
 		cell := t.GetCell(i, 0)
 		ref, ok := cell.GetReference().(*GroupCellRef)
 		if ok {
 			if ref.Group.Title == g.Title {
 				targetRow = i
+				cellRef = ref
+				cellRef.Group = g
 				break
 			}
 		}
 	}
 
-	cell := tview.NewTableCell(g.Title).SetReference(NewGroupCellRef(g))
+	cell := tview.NewTableCell(withUnreadCount(g.Title, unread)).SetReference(cellRef)
 
 	t.SetCell(targetRow, 0, cell)
 
@@ -0,0 +1,133 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	fd "github.com/yitose/rssviewer/internal/feed"
+)
+
+// HealthTable lists how the fetches of each feed are going.
+type HealthTable struct {
+	*tview.Table
+	// ProblemsOnly hides the feeds which are doing fine.
+	ProblemsOnly bool
+}
+
+var healthColumns = []string{"Feed", "Last Fetch", "Status", "Latency", "Size", "Items", "Failures", "Last Item"}
+
+// resetHealth lists the feeds in the health table again, keeping the
+// selected feed.
+func (t *Tui) resetHealth() {
+	h := t.HealthWidget
+
+	selected := ""
+	if row, _ := h.GetSelection(); row > 0 {
+		if f, ok := h.GetCell(row, 0).GetReference().(*fd.Feed); ok {
+			selected = f.FeedLink
+		}
+	}
+
+	h.Clear()
+	for i, title := range healthColumns {
+		h.SetCell(0, i, tview.NewTableCell(title).
+			SetAttributes(tcell.AttrBold).
+			SetSelectable(false))
+	}
+
+	row := 1
+	for _, f := range t.DB.Feed {
+		hasProblem := t.Config.HasProblem(f)
+		if h.ProblemsOnly && !hasProblem {
+			continue
+		}
+
+		color := tcell.Color(f.Color + 1<<32)
+		if hasProblem {
+			color = tcell.ColorRed
+		}
+		for i, text := range healthRow(f) {
+			cell := tview.NewTableCell(tview.Escape(text)).SetTextColor(color)
+			if i == 0 {
+				cell.SetReference(f).SetMaxWidth(40)
+			}
+			h.SetCell(row, i, cell)
+		}
+		if f.FeedLink == selected {
+			h.Select(row, 0)
+		}
+		row++
+	}
+
+	if selected == "" || h.GetRowCount() <= 1 {
+		h.Select(1, 0)
+	}
+
+	title := healthWidgetTitle
+	if h.ProblemsOnly {
+		title += " - Problems"
+	}
+	h.SetTitle(title)
+
+	t.healthTableSelectionChangedFunc(h.GetSelection())
+}
+
+func healthRow(f *fd.Feed) []string {
+	row := []string{f.Title, "-", "-", "-", "-", fmt.Sprint(len(f.Items)), fmt.Sprint(f.FailureCount), "-"}
+	if fetch := f.LastFetch(); fetch != nil {
+		row[1] = fd.FormatDate(&fetch.At)
+		row[2] = fetch.StatusText()
+		row[3] = fetch.Latency.Round(time.Millisecond).String()
+		row[4] = fd.FormatSize(fetch.Size)
+	}
+	if last := f.LastPublished(); last != nil {
+		row[7] = fd.FormatDate(last)
+	}
+	return row
+}
+
+func (t *Tui) healthTableSelectionChangedFunc(row, column int) {
+	f, ok := t.HealthWidget.GetCell(row, 0).GetReference().(*fd.Feed)
+	if !ok {
+		t.HealthDetailWidget.SetText("")
+		return
+	}
+
+	s := fmt.Sprint("[#a0a0a0::b]URL[-::-] ", tview.Escape(f.FeedLink), "\n")
+	if f.IsStale() {
+		s += fmt.Sprint("[#a0a0a0::b]Last Error[-::-] ", tview.Escape(f.LastError), "\n")
+	}
+	s += "[#a0a0a0::b]History[-::-]\n"
+	for i := len(f.History) - 1; i >= 0; i-- {
+		fetch := f.History[i]
+		s += fmt.Sprintf("%s  %-6s %8s %8s %4d items",
+			fd.FormatDate(&fetch.At), fetch.StatusText(), fetch.Latency.Round(time.Millisecond), fd.FormatSize(fetch.Size), fetch.Items)
+		if fetch.Error != "" {
+			s += "  [#ff0000]" + tview.Escape(fetch.Error) + "[-]"
+		}
+		s += "\n"
+	}
+	t.HealthDetailWidget.SetText(s).ScrollToBeginning()
+}
+
+func (t *Tui) showHealth() {
+	t.resetHealth()
+	t.Pages.ShowPage(healthPage)
+	t.App.SetFocus(t.HealthWidget)
+	t.Help([][]string{
+		{"p", "problems only"},
+		{"Esc", "close"},
+	})
+}
+
+func (t *Tui) hideHealth() {
+	t.Pages.HidePage(healthPage)
+	t.focusLeftTable(t.CurrentLeftTable)
+}
+
+func (t *Tui) isHealthShown() bool {
+	name, _ := t.Pages.GetFrontPage()
+	return name == healthPage
+}
@@ -8,6 +8,9 @@ import (
 
 type ItemTable struct {
 	*tview.Table
+	// isRestoring is set while setItems selects a row, which is not the user
+	// reading an item.
+	isRestoring bool
 }
 
 func (i *ItemTable) GetItem(index int) (*fd.Item, error) {
@@ -31,5 +34,43 @@ func (t *ItemTable) setCell(i *fd.Item) {
 	}
 	t.SetCell(targetRow, 0, tview.NewTableCell(i.Title).
 		SetTextColor(tcell.Color(i.Color+1<<32)).
+		SetAttributes(itemAttributes(i)).
 		SetReference(i))
 }
+
+// setItems lists items and selects the one identified by key, or the row
+// cursor if it is no longer listed.
+func (t *ItemTable) setItems(items []*fd.Item, key string, cursor int) {
+	t.Clear()
+	for _, i := range items {
+		t.setCell(i)
+	}
+
+	row := cursor
+	for j := 0; j < t.GetRowCount(); j++ {
+		if i, ok := t.GetCell(j, 0).GetReference().(*fd.Item); ok && i.Key() == key {
+			row = j
+			break
+		}
+	}
+	t.isRestoring = true
+	t.Select(row, 0)
+	t.isRestoring = false
+}
+
+// updateReadState restyles the rows after items were marked read or unread.
+func (t *ItemTable) updateReadState() {
+	for j := 0; j < t.GetRowCount(); j++ {
+		cell := t.GetCell(j, 0)
+		if i, ok := cell.GetReference().(*fd.Item); ok {
+			cell.SetAttributes(itemAttributes(i))
+		}
+	}
+}
+
+func itemAttributes(i *fd.Item) tcell.AttrMask {
+	if i.IsRead {
+		return tcell.AttrDim
+	}
+	return tcell.AttrBold
+}
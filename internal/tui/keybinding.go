@@ -2,16 +2,16 @@ package tui
 
 import (
 	"errors"
-	"os"
 	"strconv"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	db "github.com/yitose/rssviewer/internal/db"
 	fd "github.com/yitose/rssviewer/internal/feed"
+	"github.com/yitose/rssviewer/pkg/util"
 )
 
-const msgRefusedByLoading = "It is not allowed during loading."
+const msgRefusedByLoading = "It is not allowed while importing feeds."
 
 func (t *Tui) setKeyBinding() {
 	t.App.SetInputCapture(t.appInputCaptureFunc)
@@ -21,10 +21,12 @@ func (t *Tui) setKeyBinding() {
 	t.InputWidget.SetInputCapture(t.inputWidgetInputCaptureFunc)
 	t.DescriptionWidget.SetInputCapture(t.descriptionWidgetInputCaptureFunc)
 	t.ColorWidget.SetInputCapture(t.colorWidgetInputCaptureFunc)
+	t.HealthWidget.SetInputCapture(t.healthWidgetInputCaptureFunc)
+	t.PickerWidget.SetInputCapture(t.pickerWidgetInputCaptureFunc)
 }
 
 func (t *Tui) appInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
-	if t.InputWidget.HasFocus() {
+	if t.InputWidget.HasFocus() || t.HealthWidget.HasFocus() || t.PickerWidget.HasFocus() {
 		return event
 	}
 
@@ -34,80 +36,58 @@ func (t *Tui) appInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 
 	switch event.Rune() {
 	case 'e':
-		if t.IsLoading {
-			t.Notify(msgRefusedByLoading, true)
-		} else {
-			if t.ConfirmationStatus == 'e' {
-				listFile, err := os.Create(db.ExportListPath)
-				if err != nil {
-					panic(err)
-				}
-				defer listFile.Close()
-				for _, f := range t.DB.Feed {
-					if _, err := listFile.WriteString(f.FeedLink + "\n"); err != nil {
-						panic(err)
-					}
-				}
-				t.Notify("Exported to "+db.ExportListPath+".", false)
-				t.ConfirmationStatus = defaultConfirmationStatus
-			} else {
-				t.Notify("Press e again to export feed urls.", false)
-				t.ConfirmationStatus = 'e'
-			}
-		}
+		t.InputWidget.SetTitle("Export")
+		t.InputWidget.Mode = 'e'
+		t.InputWidget.SetText(db.ExportOPMLPath)
+		t.Pages.ShowPage(inputField)
+		t.App.SetFocus(t.InputWidget)
+		t.Notify("Enter a file path to export to. Paths ending in .opml are exported as OPML.", false)
+		return nil
 	case 'q':
 		t.App.Stop()
 	case 'n':
+		t.InputWidget.SetTitle("New Feed")
+		t.InputWidget.Mode = 'n'
+		t.Pages.ShowPage(inputField)
+		t.App.SetFocus(t.InputWidget)
+		t.Notify("Enter a feed URL, a shorthand like gh:owner/repo, a website URL, the path of a feed file or a directory, git: followed by a repository path, mail: followed by an mbox file or a Maildir folder, or exec: followed by a command to output feed as xml.", false)
+		return nil
+	case 'i':
 		if t.IsLoading {
 			t.Notify(msgRefusedByLoading, true)
+		} else if t.cancelRefresh != nil {
+			t.Notify("It is not allowed while refreshing feeds. Press x to cancel.", true)
 		} else {
-			t.InputWidget.SetTitle("New Feed")
-			t.InputWidget.Mode = 'n'
+			path := db.ImportOPMLPath
+			if !util.IsFile(path) {
+				path = db.ImportListPath
+			}
+			t.InputWidget.SetTitle("Import")
+			t.InputWidget.Mode = 'i'
+			t.InputWidget.SetText(path)
 			t.Pages.ShowPage(inputField)
 			t.App.SetFocus(t.InputWidget)
-			t.Notify("Enter a feed URL or a command to output feed as xml.", false)
+			t.Notify("Enter an OPML file or a list of URLs to import.", false)
 			return nil
 		}
-	case 'i':
-		if t.IsLoading {
-			t.Notify(msgRefusedByLoading, true)
-		} else {
-			if t.ConfirmationStatus == 'i' {
-				go func() {
-					if err := t.AddFeedsFromURL(db.ImportListPath); err != nil {
-						if err == ErrImportFileNotFound {
-							t.Notify("import failed:"+err.Error(), true)
-						} else {
-							panic(err)
-						}
-					} else {
-						t.Notify("Imported from "+db.ImportListPath+".", false)
-					}
-					t.App.QueueUpdateDraw(func() {})
-				}()
-				t.ConfirmationStatus = defaultConfirmationStatus
-			} else {
-				t.Notify("Press i again to import from "+db.ImportListPath+".", false)
-				t.ConfirmationStatus = 'i'
-			}
-		}
+	case 'H':
+		t.showHealth()
+		return nil
 	case 'D':
-		if t.IsLoading {
-			t.Notify(msgRefusedByLoading, true)
-		} else {
-			t.Pages.ShowPage(descriptionField)
-			t.App.SetFocus(t.DescriptionWidget)
-		}
+		t.Pages.ShowPage(descriptionField)
+		t.App.SetFocus(t.DescriptionWidget)
 	case 'R':
 		if t.IsLoading {
 			t.Notify(msgRefusedByLoading, true)
+		} else if t.cancelRefresh != nil {
+			t.Notify("Feeds are already being refreshed. Press x to cancel.", false)
 		} else {
-			go func() {
-				if err := t.UpdateAllFeed(); err != nil {
-					panic(err)
-				}
-				t.App.QueueUpdateDraw(func() {})
-			}()
+			t.startRefresh(t.DB.Feed)
+		}
+	case 'x':
+		if t.cancelRefresh != nil {
+			t.stopRefresh()
+			t.Notify("Cancelling...", false)
 		}
 	}
 
@@ -121,31 +101,35 @@ func (t *Tui) groupTableInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey
 
 	switch event.Rune() {
 	case 'd':
-		if t.IsLoading {
-			t.Notify(msgRefusedByLoading, true)
-		} else {
-			if t.ConfirmationStatus == 'd' {
-				cell := t.GroupWidget.GetCell(t.GroupWidget.GetSelection())
-				ref, ok := cell.GetReference().(*GroupCellRef)
-				if ok {
-					if ref.Group.Title == db.TodaysFeedTitle {
-						t.Notify(db.TodaysFeedTitle+" is an automatically generated group, and cannot be removed.", true)
-					} else {
-						if err := t.DB.DeleteGroup(ref.Group); err != nil {
-							panic(err)
-						}
-						t.Notify("deleted.", false)
-					}
+		if t.ConfirmationStatus == 'd' {
+			cell := t.GroupWidget.GetCell(t.GroupWidget.GetSelection())
+			ref, ok := cell.GetReference().(*GroupCellRef)
+			if ok {
+				if ref.Group.Title == db.TodaysFeedTitle {
+					t.Notify(db.TodaysFeedTitle+" is an automatically generated group, and cannot be removed.", true)
 				} else {
-					t.Notify("delete failed.", true)
+					if err := t.DB.DeleteGroup(ref.Group); err != nil {
+						panic(err)
+					}
+					t.Notify("deleted.", false)
 				}
-				t.ConfirmationStatus = defaultConfirmationStatus
-				t.resetGroups(t.DB.Group)
-				t.GroupWidget.Select(t.GroupWidget.GetSelection())
 			} else {
-				t.Notify("Press d again to delete this feed.", false)
-				t.ConfirmationStatus = 'd'
+				t.Notify("delete failed.", true)
 			}
+			t.ConfirmationStatus = defaultConfirmationStatus
+			t.resetGroups(t.DB.Group)
+			t.GroupWidget.Select(t.GroupWidget.GetSelection())
+		} else {
+			t.Notify("Press d again to delete this feed.", false)
+			t.ConfirmationStatus = 'd'
+		}
+	case 'a':
+		cell := t.GroupWidget.GetCell(t.GroupWidget.GetSelection())
+		if ref, ok := cell.GetReference().(*GroupCellRef); ok {
+			if err := t.markItems(t.DB.GetGroupItems(ref.Group), true); err != nil {
+				panic(err)
+			}
+			t.Notify("marked as read.", false)
 		}
 	case 'j':
 		row, _ := t.GroupWidget.GetSelection()
@@ -170,7 +154,7 @@ func (t *Tui) feedTableInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 	switch event.Rune() {
 	case 'c':
 		t.ColorWidget.Clear()
-		for i, c := range t.getColorRange() {
+		for i, c := range t.Config.ColorRange() {
 			t.ColorWidget.SetCell(i, 0,
 				tview.NewTableCell(strconv.Itoa(c)).
 					SetTextColor(tcell.Color(c+1<<32)))
@@ -181,44 +165,36 @@ func (t *Tui) feedTableInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 		t.Notify("Select a color to change the feed's one.", false)
 		return nil
 	case 'd':
-		if t.IsLoading {
-			t.Notify(msgRefusedByLoading, true)
-		} else {
-			if t.ConfirmationStatus == 'd' {
-				cell := t.FeedWidget.GetCell(t.FeedWidget.GetSelection())
-				ref, ok := cell.GetReference().(*FeedCellRef)
-				if ok {
-					if err := t.DB.DeleteFeed(ref.Feed); err != nil {
-						panic(err)
-					}
-					t.Notify("deleted.", false)
-				} else {
-					t.Notify("delete failed.", true)
+		if t.ConfirmationStatus == 'd' {
+			cell := t.FeedWidget.GetCell(t.FeedWidget.GetSelection())
+			ref, ok := cell.GetReference().(*FeedCellRef)
+			if ok {
+				if err := t.DB.DeleteFeed(ref.Feed); err != nil {
+					panic(err)
 				}
-				t.ConfirmationStatus = defaultConfirmationStatus
-				t.resetGroups(t.DB.Group)
-				t.resetFeeds(t.DB.Feed)
-				t.FeedWidget.Select(t.FeedWidget.GetSelection())
+				t.Notify("deleted.", false)
 			} else {
-				t.Notify("Press d again to delete this feed.", false)
-				t.ConfirmationStatus = 'd'
+				t.Notify("delete failed.", true)
 			}
+			t.ConfirmationStatus = defaultConfirmationStatus
+			t.resetGroups(t.DB.Group)
+			t.resetFeeds(t.DB.Feed)
+			t.FeedWidget.Select(t.FeedWidget.GetSelection())
+		} else {
+			t.Notify("Press d again to delete this feed.", false)
+			t.ConfirmationStatus = 'd'
 		}
 	case 'm':
-		if t.IsLoading {
-			t.Notify(msgRefusedByLoading, true)
-		} else {
-			if len(t.SelectingFeeds) == 0 {
-				t.Notify("Select at least 1 Feed to make a Group.", true)
-				return nil
-			}
-			t.InputWidget.SetTitle("New Group")
-			t.InputWidget.Mode = 'm'
-			t.Pages.ShowPage(inputField)
-			t.setFocus(t.InputWidget.Box)
-			t.Notify("Enter a new group title.", false)
+		if len(t.SelectingFeeds) == 0 {
+			t.Notify("Select at least 1 Feed to make a Group.", true)
 			return nil
 		}
+		t.InputWidget.SetTitle("New Group")
+		t.InputWidget.Mode = 'm'
+		t.Pages.ShowPage(inputField)
+		t.setFocus(t.InputWidget.Box)
+		t.Notify("Enter a new group title.", false)
+		return nil
 	case 'v':
 		cell := t.FeedWidget.Table.GetCell(t.FeedWidget.Table.GetSelection())
 		cellRef, ok := cell.GetReference().(*FeedCellRef)
@@ -237,6 +213,26 @@ func (t *Tui) feedTableInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 				}
 			}
 		}
+	case 'a':
+		cell := t.FeedWidget.GetCell(t.FeedWidget.GetSelection())
+		if ref, ok := cell.GetReference().(*FeedCellRef); ok {
+			if err := t.markItems(ref.Feed.Items, true); err != nil {
+				panic(err)
+			}
+			t.Notify("marked as read.", false)
+		}
+	case 'E':
+		cell := t.FeedWidget.GetCell(t.FeedWidget.GetSelection())
+		if ref, ok := cell.GetReference().(*FeedCellRef); ok && ref.Feed.Disabled {
+			if err := db.ModifyFeed(ref.Feed, func(f *fd.Feed) { f.Disabled = false }); err != nil {
+				panic(err)
+			}
+			t.FeedWidget.setCell(ref.Feed)
+			if t.cancelRefresh == nil {
+				t.startRefresh([]*fd.Feed{ref.Feed})
+			}
+			t.Notify("enabled.", false)
+		}
 	case 'k':
 		row, _ := t.FeedWidget.GetSelection()
 		if row == 0 {
@@ -260,7 +256,7 @@ func (t *Tui) itemTableInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 	switch event.Rune() {
 	case 'c':
 		t.ColorWidget.Clear()
-		for i, c := range t.getColorRange() {
+		for i, c := range t.Config.ColorRange() {
 			t.ColorWidget.SetCell(i, 0,
 				tview.NewTableCell(strconv.Itoa(c)).
 					SetTextColor(tcell.Color(c+1<<32)))
@@ -281,6 +277,19 @@ func (t *Tui) itemTableInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 		if err := openURL(item.Link); err != nil {
 			panic(err)
 		}
+		if err := t.markItems([]*fd.Item{item}, true); err != nil {
+			panic(err)
+		}
+		return nil
+	case 'u':
+		row, _ := t.ItemWidget.GetSelection()
+		item, err := t.ItemWidget.GetItem(row)
+		if err != nil {
+			return nil
+		}
+		if err := t.markItems([]*fd.Item{item}, !item.IsRead); err != nil {
+			panic(err)
+		}
 		return nil
 	}
 
@@ -290,6 +299,10 @@ func (t *Tui) itemTableInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 func (t *Tui) inputWidgetInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 	switch event.Key() {
 	case tcell.KeyEscape:
+		if t.pendingImport != nil {
+			t.pendingImport = nil
+			t.Notify("Import cancelled.", false)
+		}
 		t.Pages.SwitchToPage(mainPage)
 		t.focusLeftTable(t.CurrentLeftTable)
 		t.InputWidget.SetText("")
@@ -311,6 +324,23 @@ func (t *Tui) inputWidgetInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey
 			if err := t.AddFeedFromURL(t.InputWidget.GetText()); err != nil {
 				t.Notify(err.Error(), true)
 			}
+		case 'e':
+			path := t.InputWidget.GetText()
+			if err := t.DB.Export(path, t.Config); err != nil {
+				t.Notify("export failed: "+err.Error(), true)
+			} else {
+				t.Notify("Exported to "+path+".", false)
+			}
+		case 'i':
+			if err := t.ImportFeeds(t.InputWidget.GetText()); err != nil {
+				t.Notify("import failed: "+err.Error(), true)
+			}
+			if t.pendingImport != nil {
+				// Keep the input open for the confirmation.
+				return nil
+			}
+		case 'c':
+			t.confirmImport(t.InputWidget.GetText())
 		}
 		db.SortGroup(t.DB.Group)
 		t.resetGroups(t.DB.Group)
@@ -338,6 +368,47 @@ func (t *Tui) descriptionWidgetInputCaptureFunc(event *tcell.EventKey) *tcell.Ev
 	return nil
 }
 
+func (t *Tui) healthWidgetInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		t.hideHealth()
+		return nil
+	}
+
+	switch event.Rune() {
+	case 'p':
+		t.HealthWidget.ProblemsOnly = !t.HealthWidget.ProblemsOnly
+		t.resetHealth()
+		return nil
+	case 'q', 'H':
+		t.hideHealth()
+		return nil
+	}
+
+	return event
+}
+
+func (t *Tui) pickerWidgetInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		t.Pages.HidePage(pickerPage)
+		t.focusLeftTable(t.CurrentLeftTable)
+		return nil
+	case tcell.KeyEnter:
+		cell := t.PickerWidget.GetCell(t.PickerWidget.GetSelection())
+		if c, ok := cell.GetReference().(*fd.Candidate); ok {
+			if err := t.addFeed(&db.Subscription{URL: c.URL, Title: c.Title}); err != nil {
+				t.Notify(err.Error(), true)
+			}
+		}
+		t.Pages.HidePage(pickerPage)
+		t.focusLeftTable(t.CurrentLeftTable)
+		return nil
+	}
+
+	return event
+}
+
 func (t *Tui) colorWidgetInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey {
 	switch event.Key() {
 	case tcell.KeyEscape:
@@ -357,11 +428,10 @@ func (t *Tui) colorWidgetInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey
 			cell := t.FeedWidget.GetCell(t.FeedWidget.GetSelection())
 			ref, ok := cell.GetReference().(*FeedCellRef)
 			if ok {
-				ref.Feed.SetColor(color)
-				t.FeedWidget.setCell(ref.Feed)
-				if err := db.SaveFeed(ref.Feed); err != nil {
+				if err := db.ModifyFeed(ref.Feed, func(f *fd.Feed) { f.SetColor(color) }); err != nil {
 					panic(err)
 				}
+				t.FeedWidget.setCell(ref.Feed)
 				t.Notify("recolored.", false)
 				t.feedTableSelectionChangedFunc(t.FeedWidget.GetSelection())
 			} else {
@@ -375,11 +445,10 @@ func (t *Tui) colorWidgetInputCaptureFunc(event *tcell.EventKey) *tcell.EventKey
 			item, ok := cell.GetReference().(*fd.Item)
 			if ok {
 				parentFeed := t.DB.GetItemParent(item)
-				parentFeed.SetColor(color)
-				t.FeedWidget.setCell(parentFeed)
-				if err := db.SaveFeed(parentFeed); err != nil {
+				if err := db.ModifyFeed(parentFeed, func(f *fd.Feed) { f.SetColor(color) }); err != nil {
 					panic(err)
 				}
+				t.FeedWidget.setCell(parentFeed)
 
 				// SelectionChangedFuncを発火して色の変更を反映する
 				t.focusLeftTable(t.CurrentLeftTable)
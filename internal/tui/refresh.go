@@ -0,0 +1,167 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	db "github.com/yitose/rssviewer/internal/db"
+	fd "github.com/yitose/rssviewer/internal/feed"
+	"github.com/yitose/rssviewer/internal/refresh"
+)
+
+const autoRefreshTick = time.Minute
+
+// autoRefresh refreshes every feed at startup, and then the feeds which are
+// due every minute if enabled in the config.
+func (t *Tui) autoRefresh() {
+	t.App.QueueUpdateDraw(func() {
+		t.startRefresh(t.DB.Feed)
+	})
+
+	if !t.Config.AutoRefresh {
+		return
+	}
+	for {
+		time.Sleep(autoRefreshTick)
+		t.App.QueueUpdateDraw(func() {
+			if t.cancelRefresh != nil || t.IsLoading {
+				return
+			}
+			now := time.Now()
+			due := []*fd.Feed{}
+			for _, f := range t.DB.Feed {
+				if !f.Disabled && !t.Config.NextUpdate(f).After(now) {
+					due = append(due, f)
+				}
+			}
+			t.startRefresh(due)
+		})
+	}
+}
+
+// startRefresh fetches feeds in the background with the refresh engine.
+// Results are applied on the UI goroutine, so the user can keep working
+// meanwhile. It must be called from the UI goroutine.
+func (t *Tui) startRefresh(feeds []*fd.Feed) {
+	if t.cancelRefresh != nil {
+		return
+	}
+
+	// The fetches work on copies, since the feeds may be changed or replaced
+	// on the UI goroutine while they run.
+	jobs := []*refresh.Job{}
+	probes := map[*refresh.Job]*fd.Feed{}
+	for _, f := range feeds {
+		if f.Disabled {
+			continue
+		}
+		probe := *f
+		job := t.Config.UpdateJob(&probe)
+		jobs = append(jobs, job)
+		probes[job] = &probe
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancelRefresh = cancel
+	t.setRefreshStatus(0, len(jobs))
+
+	engine := t.Config.Engine()
+	// notes are only touched on the UI goroutine.
+	notes := []string{}
+	go func() {
+		done := 0
+		engine.Run(ctx, jobs, func(r *refresh.Result) {
+			done++
+			n := done
+			t.App.QueueUpdateDraw(func() {
+				if note := t.applyRefresh(probes[r.Job], r); note != "" {
+					notes = append(notes, note)
+				}
+				t.setRefreshStatus(n, len(jobs))
+			})
+		})
+
+		t.App.QueueUpdateDraw(func() {
+			if ctx.Err() != nil {
+				t.Notify("Refresh cancelled.", false)
+			} else if len(notes) > 0 {
+				t.Notify(strings.Join(notes, " "), true)
+			} else if t.FeedWidget.GetRowCount() > 0 {
+				t.Notify("All feeds are up to date.", false)
+			}
+			cancel()
+			t.cancelRefresh = nil
+			t.InfoWidget.SetTitle(infoWidgetTitle)
+			t.startQueuedMailbox()
+		})
+	}()
+}
+
+func (t *Tui) stopRefresh() {
+	if t.cancelRefresh != nil {
+		t.cancelRefresh()
+	}
+}
+
+func (t *Tui) setRefreshStatus(done, total int) {
+	t.InfoWidget.SetTitle(fmt.Sprintf("%s - Refreshing (%d/%d) [x] cancel", infoWidgetTitle, done, total))
+}
+
+// applyRefresh puts the result of fetching probe in place of the feed it was
+// copied from, unless that feed has been deleted in the meantime. A failed
+// fetch leaves the feed as it was, only marked as stale. It returns a note
+// for the user if the feed has moved, is gone or has been removed by another
+// rssviewer process.
+func (t *Tui) applyRefresh(probe *fd.Feed, r *refresh.Result) string {
+	link := probe.FeedLink
+	current := t.DB.GetFeed(link)
+	if current == nil {
+		return ""
+	}
+
+	note := ""
+	newFeed := r.Feed
+	if r.Err != nil {
+		f, err := t.DB.RecordFailure(current, r.Fetch)
+		if err == db.ErrFeedRemoved {
+			note = fmt.Sprintf("%s has been removed elsewhere.", current.Title)
+		} else if err != nil {
+			t.Notify(err.Error(), true)
+		}
+		current = f
+		if err != db.ErrFeedRemoved && current.Disabled {
+			note = fmt.Sprintf("%s is gone and has been disabled.", current.Title)
+		}
+	} else {
+		if newFeed == probe {
+			current.LastFetched = probe.LastFetched
+			newFeed = current
+		}
+		f, err := t.DB.ApplyUpdate(current, newFeed, r.Fetch, t.Config.Retention(link))
+		if err == db.ErrFeedRemoved {
+			note = fmt.Sprintf("%s has been removed elsewhere.", current.Title)
+		} else if err != nil {
+			t.Notify(err.Error(), true)
+			return ""
+		} else if f.FeedLink != link {
+			note = fmt.Sprintf("%s has moved to %s.", f.Title, f.FeedLink)
+			if err := t.Config.MoveFeed(link, f.FeedLink); err != nil {
+				t.Notify(err.Error(), true)
+			}
+		}
+	}
+
+	db.SortFeed(t.DB.Feed)
+	t.resetFeeds(t.DB.Feed)
+	t.resetGroups(t.DB.Group)
+	t.reloadItems()
+	if t.isHealthShown() {
+		t.resetHealth()
+	}
+	return note
+}
@@ -2,9 +2,7 @@ package tui
 
 import (
 	"fmt"
-	"time"
 
-	db "github.com/yitose/rssviewer/internal/db"
 	fd "github.com/yitose/rssviewer/internal/feed"
 )
 
@@ -18,6 +16,8 @@ func (t *Tui) commonKeyHelp() [][]string {
 		help = append(help, [][]string{
 			{"e", "export"},
 			{"R", "update"},
+			{"x", "cancel"},
+			{"H", "health"},
 			{"D", "description"},
 		}...)
 	}
@@ -31,6 +31,7 @@ func (t *Tui) setSelectionFunc() {
 	t.GroupWidget.Table.SetSelectionChangedFunc(t.groupTableSelectionChangedFunc)
 	t.FeedWidget.Table.SetSelectionChangedFunc(t.feedTableSelectionChangedFunc)
 	t.ItemWidget.SetSelectionChangedFunc(t.itemTableSelectionChangedFunc)
+	t.HealthWidget.SetSelectionChangedFunc(t.healthTableSelectionChangedFunc)
 }
 
 func (t *Tui) groupTableSelectionChangedFunc(row, column int) {
@@ -49,6 +50,7 @@ func (t *Tui) groupTableSelectionChangedFunc(row, column int) {
 	}
 
 	help = append(help, []string{"d", "delete"})
+	help = append(help, []string{"a", "mark read"})
 	help = append(help, []string{"\n", ""})
 	t.Help(append(help, t.commonKeyHelp()...))
 
@@ -60,40 +62,11 @@ func (t *Tui) groupTableSelectionChangedFunc(row, column int) {
 	}
 	t.Descript(desc)
 
-	items := []*fd.Item{}
-
-	if group.Title == db.TodaysFeedTitle {
-		now := time.Now()
-		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-		for i := 0; i < t.FeedWidget.GetRowCount(); i++ {
-			ref, ok := t.FeedWidget.GetCell(i, 0).GetReference().(*FeedCellRef)
-			if ok {
-				for _, i := range ref.Feed.Items {
-					if i.PublishedParsed != nil && !today.After(*i.PublishedParsed) {
-						items = append(items, i)
-					}
-				}
-			}
-		}
-	} else {
-		for _, link := range group.FeedLinks {
-			for _, f := range t.DB.Feed {
-				if f.FeedLink == link {
-					items = append(items, f.Items...)
-				}
-			}
-		}
-	}
-
-	t.ItemWidget.Clear()
-
+	items := t.DB.GetGroupItems(group)
 	fd.SortItems(items)
 
-	for _, i := range items {
-		t.ItemWidget.setCell(i)
-	}
-
-	t.ItemWidget.ScrollToBeginning().Select(cellRef.Cursor, 0)
+	t.ItemWidget.ScrollToBeginning()
+	t.ItemWidget.setItems(items, cellRef.ItemKey, cellRef.Cursor)
 
 	t.ConfirmationStatus = defaultConfirmationStatus
 }
@@ -119,16 +92,17 @@ func (t *Tui) feedTableSelectionChangedFunc(row, column int) {
 		{"d", "delete"},
 		{"v", "select"},
 		{"m", "make"},
+		{"a", "mark read"},
 	}...)
-	help = append(help, []string{"\n", ""})
-	t.Help(append(help, t.commonKeyHelp()...))
-
-	t.ItemWidget.Clear()
 
 	cellRef := t.FeedWidget.GetCell(row, column).GetReference().(*FeedCellRef)
 	feed := cellRef.Feed
 
-	t.ItemWidget.ScrollToBeginning().Select(cellRef.Cursor, 0)
+	if feed.Disabled {
+		help = append(help, []string{"E", "enable"})
+	}
+	help = append(help, []string{"\n", ""})
+	t.Help(append(help, t.commonKeyHelp()...))
 
 	desc := [][]string{
 		{"Title", feed.Title},
@@ -137,11 +111,20 @@ func (t *Tui) feedTableSelectionChangedFunc(row, column int) {
 		{"ColorCode", fmt.Sprint(feed.Color)},
 		{"URL", feed.FeedLink},
 	}
+	if feed.Disabled {
+		desc = append(desc, []string{"Disabled", "The feed is gone. Press E to enable it again."})
+	}
+	if feed.IsStale() {
+		desc = append(desc, [][]string{
+			{"Last Error", feed.LastError},
+			{"Failed At", fd.FormatDate(&feed.LastErrorAt)},
+			{"Failures", fmt.Sprint(feed.FailureCount)},
+		}...)
+	}
 	t.Descript(desc)
 
-	for _, i := range feed.Items {
-		t.ItemWidget.setCell(i)
-	}
+	t.ItemWidget.ScrollToBeginning()
+	t.ItemWidget.setItems(feed.Items, cellRef.ItemKey, cellRef.Cursor)
 
 	t.ConfirmationStatus = defaultConfirmationStatus
 }
@@ -164,30 +147,39 @@ func (t *Tui) itemTableSelectionChangedFunc(row, column int) {
 
 	help = append(help, [][]string{
 		{"o", "open"},
+		{"u", "read/unread"},
 		{"c", "recolor"},
 	}...)
 	help = append(help, []string{"\n", ""})
 	t.Help(append(help, t.commonKeyHelp()...))
 
+	cell := t.ItemWidget.GetCell(row, column)
+	item := cell.GetReference().(*fd.Item)
+
 	switch t.CurrentLeftTable {
 	case enumGroupWidget:
 		cell := t.GroupWidget.GetCell(t.GroupWidget.GetSelection())
 		cellRef, ok := cell.GetReference().(*GroupCellRef)
 		if ok {
 			cellRef.Cursor = row
+			cellRef.ItemKey = item.Key()
 		}
 	case enumFeedWidget:
 		cell := t.FeedWidget.GetCell(t.FeedWidget.GetSelection())
 		cellRef, ok := cell.GetReference().(*FeedCellRef)
 		if ok {
 			cellRef.Cursor = row
+			cellRef.ItemKey = item.Key()
 		}
 	default:
 		return
 	}
 
-	cell := t.ItemWidget.GetCell(row, column)
-	item := cell.GetReference().(*fd.Item)
+	if !t.ItemWidget.isRestoring {
+		if err := t.markItems([]*fd.Item{item}, true); err != nil {
+			t.Notify(err.Error(), true)
+		}
+	}
 
 	author := ""
 	if item.Author != nil {
@@ -210,3 +202,22 @@ func (t *Tui) itemTableSelectionChangedFunc(row, column int) {
 
 	t.ConfirmationStatus = defaultConfirmationStatus
 }
+
+// reloadItems lists the items of the selected group or feed again, keeping
+// the selected item, regardless of which widget has focus.
+func (t *Tui) reloadItems() {
+	switch t.CurrentLeftTable {
+	case enumGroupWidget:
+		cellRef, ok := t.GroupWidget.GetCell(t.GroupWidget.GetSelection()).GetReference().(*GroupCellRef)
+		if ok {
+			items := t.DB.GetGroupItems(cellRef.Group)
+			fd.SortItems(items)
+			t.ItemWidget.setItems(items, cellRef.ItemKey, cellRef.Cursor)
+		}
+	case enumFeedWidget:
+		cellRef, ok := t.FeedWidget.GetCell(t.FeedWidget.GetSelection()).GetReference().(*FeedCellRef)
+		if ok {
+			t.ItemWidget.setItems(cellRef.Feed.Items, cellRef.ItemKey, cellRef.Cursor)
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package tui
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/pkg/errors"
 	"github.com/rivo/tview"
-	"github.com/yitose/rssviewer/internal/color"
 	db "github.com/yitose/rssviewer/internal/db"
 	fd "github.com/yitose/rssviewer/internal/feed"
+	"github.com/yitose/rssviewer/internal/refresh"
 	"github.com/yitose/rssviewer/pkg/util"
 )
 
@@ -31,11 +34,25 @@ type Tui struct {
 	HelpWidget         *tview.TextView
 	InputWidget        *InputBox
 	ColorWidget        *tview.Table
+	HealthWidget       *HealthTable
+	HealthDetailWidget *tview.TextView
+	PickerWidget       *tview.Table
 	SelectingFeeds     []*fd.Feed
 	LastFocusedWidget  *tview.Box
 	ConfirmationStatus rune
 	CurrentLeftTable   int
 	IsLoading          bool
+	cancelRefresh      context.CancelFunc
+	// pendingImport waits for the user to confirm its command feeds.
+	pendingImport *pendingImport
+	// queuedMailboxes are read once the running refresh or import is done.
+	queuedMailboxes []*db.Subscription
+}
+
+type pendingImport struct {
+	path   string
+	subs   []*db.Subscription
+	groups []*fd.Group
 }
 
 const (
@@ -44,6 +61,8 @@ const (
 	colorTable                = "ColorTablePopup"
 	mainPage                  = "MainPage"
 	keymapPage                = "KeymapPage"
+	healthPage                = "HealthPage"
+	pickerPage                = "PickerPopup"
 	defaultConfirmationStatus = '0'
 	groupWidgetTitle          = "Groups"
 	FeedWidgetTitle           = "Feeds"
@@ -51,6 +70,9 @@ const (
 	descriptionWidgetTitle    = "Description"
 	infoWidgetTitle           = "Info"
 	colorWidgetTitle          = "Color"
+	healthWidgetTitle         = "Health"
+	historyWidgetTitle        = "History"
+	pickerWidgetTitle         = "Select a Feed"
 )
 
 const (
@@ -58,13 +80,18 @@ const (
 	enumFeedWidget
 )
 
-var ErrImportFileNotFound = errors.Errorf(db.ImportListPath + " not found")
+var ErrImportFileNotFound = errors.Errorf("file not found")
 
-func NewTui() *Tui {
+func NewTui() (*Tui, error) {
 	tview.Styles.ContrastBackgroundColor = tview.Styles.PrimitiveBackgroundColor
 
+	config, err := db.LoadOrNewConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	tui := &Tui{
-		Config:             db.LoadOrNewConfig(),
+		Config:             config,
 		DB:                 db.NewDB(),
 		App:                tview.NewApplication(),
 		Pages:              tview.NewPages(),
@@ -76,6 +103,9 @@ func NewTui() *Tui {
 		HelpWidget:         tview.NewTextView().SetTextAlign(1).SetDynamicColors(true),
 		InputWidget:        &InputBox{InputField: newInputField(), Mode: 0},
 		ColorWidget:        newTable(colorWidgetTitle),
+		HealthWidget:       &HealthTable{Table: newTable(healthWidgetTitle)},
+		HealthDetailWidget: newTextView(historyWidgetTitle),
+		PickerWidget:       newTable(pickerWidgetTitle),
 		SelectingFeeds:     []*fd.Feed{},
 		LastFocusedWidget:  nil,
 		ConfirmationStatus: defaultConfirmationStatus,
@@ -121,10 +151,26 @@ func NewTui() *Tui {
 			AddItem(nil, 0, 1, false), 40, 1, false).
 		AddItem(nil, 0, 1, false)
 
+	pickerFlex := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(tui.PickerWidget, 0, 2, false).
+			AddItem(nil, 0, 1, false), 0, 3, false).
+		AddItem(nil, 0, 1, false)
+
+	tui.HealthWidget.SetFixed(1, 0)
+	healthFlex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(tui.HealthWidget, 0, 2, false).
+		AddItem(tui.HealthDetailWidget, 0, 1, false).
+		AddItem(tui.HelpWidget, 2, 0, false)
+
 	tui.Pages.
 		AddPage(mainPage, mainFlex, true, true).
+		AddPage(healthPage, healthFlex, true, false).
 		AddPage(inputField, inputFlex, true, false).
 		AddPage(colorTable, colorTableFlex, true, false).
+		AddPage(pickerPage, pickerFlex, true, false).
 		AddPage(descriptionField, descriptionFlex, true, false)
 
 	tui.App.SetRoot(tui.Pages, true)
@@ -134,7 +180,7 @@ func NewTui() *Tui {
 	tui.setFocusFunc()
 	tui.setBlurFunc()
 
-	return tui
+	return tui, nil
 }
 
 func (t *Tui) setFocus(p *tview.Box) {
@@ -142,26 +188,6 @@ func (t *Tui) setFocus(p *tview.Box) {
 	t.App.SetFocus(p)
 }
 
-func (t *Tui) getRandomColor() int {
-	maxHue := t.Config.Color.MaxHue
-	minHue := t.Config.Color.MinHue
-	maxSaturatio := t.Config.Color.MaxSaturatio
-	minSaturatio := t.Config.Color.MinSaturatio
-	maxLightness := t.Config.Color.MaxLightness
-	minLightness := t.Config.Color.MinLightness
-	return color.GetRandomColor(maxHue, minHue, maxSaturatio, minSaturatio, maxLightness, minLightness)
-}
-
-func (t *Tui) getColorRange() []int {
-	maxHue := t.Config.Color.MaxHue
-	minHue := t.Config.Color.MinHue
-	maxSaturatio := t.Config.Color.MaxSaturatio
-	minSaturatio := t.Config.Color.MinSaturatio
-	maxLightness := t.Config.Color.MaxLightness
-	minLightness := t.Config.Color.MinLightness
-	return color.GetColorRange(maxHue, minHue, maxSaturatio, minSaturatio, maxLightness, minLightness)
-}
-
 func (t *Tui) MakeGroup(title string) error {
 	if len(t.SelectingFeeds) == 0 {
 		return nil
@@ -201,147 +227,246 @@ func (t *Tui) Help(help [][]string) {
 }
 
 func (t *Tui) AddFeedFromURL(url string) error {
-	for _, f := range t.DB.Feed {
-		if f.FeedLink == url {
-			return nil
-		}
+	return t.addFeed(&db.Subscription{URL: url})
+}
+
+// addFeed fetches the feed of s in the background and adds it on the UI
+// goroutine, from which addFeed must be called.
+func (t *Tui) addFeed(s *db.Subscription) error {
+	if t.DB.GetFeed(s.URL) != nil {
+		return nil
 	}
 
-	newFeed, err := fd.GetFeedFromURL(url, t.getRandomColor())
-	if err != nil {
-		t.Notify(err.Error(), true)
+	// A mailbox is added as a group of feeds, one per list or sender.
+	if fd.IsMail(s.URL) {
+		if t.cancelRefresh != nil {
+			t.queuedMailboxes = append(t.queuedMailboxes, s)
+			t.Notify("The mailbox will be read once the current refresh is done.", false)
+			return nil
+		}
+		t.startMailboxImport(s)
 		return nil
 	}
 
-	t.DB.Feed = append(t.DB.Feed, newFeed)
+	t.fetchNewFeed(s)
+	return nil
+}
 
-	if err := db.SaveFeed(newFeed); err != nil {
-		return err
-	}
+// fetchNewFeed fetches the feed of s in the background and adds it.
+func (t *Tui) fetchNewFeed(s *db.Subscription) {
+	job := t.Config.NewFeedJob(s, t.Config.RandomColor())
+	engine := t.Config.Engine()
+	go engine.Run(context.Background(), []*refresh.Job{job}, func(r *refresh.Result) {
+		t.App.QueueUpdateDraw(func() {
+			t.insertFeed(r)
+		})
+	})
+}
 
-	db.SortFeed(t.DB.Feed)
+// insertFeed adds the feed fetched by a refresh job.
+func (t *Tui) insertFeed(r *refresh.Result) {
+	var multi *fd.MultipleFeedsError
+	if errors.As(r.Err, &multi) && !t.IsLoading {
+		t.showFeedPicker(multi.Candidates)
+		return
+	}
+	if r.Err != nil {
+		t.Notify(r.Err.Error(), true)
+		return
+	}
+	if err := t.DB.InsertFeed(r.Feed); err != nil && err != db.ErrFeedExists {
+		t.Notify(err.Error(), true)
+		return
+	}
 	t.resetFeeds(t.DB.Feed)
-
-	return nil
 }
 
-func (t *Tui) AddFeedsFromURL(path string) error {
+// ImportFeeds adds the feeds listed in an OPML file or a plain URL list at
+// path, together with the groups defined in the OPML file. The feeds are
+// fetched in the background and added on the UI goroutine, from which
+// ImportFeeds must be called. An import can be cancelled like a refresh.
+//
+// If the file has command feeds, the user is asked first whether to run
+// them, and the import continues in confirmImport.
+func (t *Tui) ImportFeeds(path string) error {
 	if !util.IsFile(path) {
 		return ErrImportFileNotFound
 	}
 
-	_, feedURLs, err := util.GetLines(path)
+	subs, groups, err := db.ReadSubscriptions(path)
 	if err != nil {
 		return err
 	}
 
-	f := func(url string, done chan<- bool) {
-		if err := t.AddFeedFromURL(url); err != nil {
-			panic(err)
+	newSubs := []*db.Subscription{}
+	for _, s := range subs {
+		if t.DB.GetFeed(s.URL) == nil {
+			newSubs = append(newSubs, s)
 		}
-		done <- true
 	}
 
-	n := len(feedURLs)
-
-	t.IsLoading = true
-
-	done := make(chan bool, n)
-	for _, url := range feedURLs {
-		go f(url, done)
+	cmds := db.CommandSubscriptions(newSubs)
+	if len(cmds) == 0 {
+		t.startImport(path, newSubs, groups)
+		return nil
 	}
 
-	c := 0
-	for i := 0; i < n; i++ {
-		t.Notify(fmt.Sprintf("Importing Feeds...(%d/%d)", c, n), false)
-		t.App.Draw()
-		<-done
-		c++
+	t.pendingImport = &pendingImport{path: path, subs: newSubs, groups: groups}
+	shown := []string{}
+	for _, s := range cmds {
+		shown = append(shown, fd.CommandOf(s.URL))
 	}
-
-	t.IsLoading = false
-
+	t.InputWidget.SetTitle(fmt.Sprintf("Run %d commands? (y/N)", len(cmds)))
+	t.InputWidget.Mode = 'c'
+	t.InputWidget.SetText("")
+	t.Notify("The import has command feeds, which will be run on every refresh: "+tview.Escape(strings.Join(shown, ", ")), true)
 	return nil
 }
 
-func (t *Tui) UpdateAllFeed() error {
-	n := len(t.DB.Feed)
-
-	f := func(url string, done chan<- *fd.Feed) {
-		feed, _ := fd.GetFeedFromURL(url, 0)
-		done <- feed
+// confirmImport continues the pending import with its command feeds if
+// answer is yes, and without them otherwise.
+func (t *Tui) confirmImport(answer string) {
+	p := t.pendingImport
+	t.pendingImport = nil
+	if p == nil {
+		return
 	}
+	subs := p.subs
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+	default:
+		subs = db.WithoutCommands(subs)
+	}
+	t.startImport(p.path, subs, p.groups)
+}
 
+func (t *Tui) startImport(path string, subs []*db.Subscription, groups []*fd.Group) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancelRefresh = cancel
 	t.IsLoading = true
+	t.runImport(ctx, cancel, path, subs, groups)
+}
 
-	done := make(chan *fd.Feed, n)
-	for _, feed := range t.DB.Feed {
-		go f(feed.FeedLink, done)
-	}
-
-	isLoadedFeedList := map[string]int{}
-	loadedFeeds := []*fd.Feed{}
-	isLoadedGroupList := map[string]bool{}
-	loadedGroups := []*fd.Group{}
-
-	c := 0
-	for i := 0; i < n; i++ {
-		f := <-done
-		isLoadedFeedList[f.FeedLink] = 1
-		for i, feed := range t.DB.Feed {
-			if feed.FeedLink == f.FeedLink {
-				c++
-				f.SetColor(feed.Color)
-				t.DB.Feed[i] = f
-				loadedFeeds = append(loadedFeeds, f)
-				t.Notify(fmt.Sprintf("Updating Feeds...(%d/%d)", c, n), false)
-			}
-		}
+// startMailboxImport reads the mailbox of s in the background, and then
+// imports its feeds as a group. Reading the mailbox counts as part of the
+// import, so it can be cancelled as well.
+func (t *Tui) startMailboxImport(s *db.Subscription) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancelRefresh = cancel
+	t.IsLoading = true
+	t.Notify("Reading the mailbox...", false)
 
-		for _, g := range t.DB.Group {
-			loadedUrlCount := 0
-			for _, link := range g.FeedLinks {
-				loadedUrlCount += isLoadedFeedList[link]
-			}
-			if loadedUrlCount == len(g.FeedLinks) && !isLoadedGroupList[g.Title] {
-				loadedGroups = append(loadedGroups, g)
-				isLoadedGroupList[g.Title] = true
+	go func() {
+		subs, g, err := db.ExpandSubscription(s)
+		t.App.QueueUpdateDraw(func() {
+			if err == nil && ctx.Err() == nil && g != nil {
+				newSubs := []*db.Subscription{}
+				for _, sub := range subs {
+					if t.DB.GetFeed(sub.URL) == nil {
+						newSubs = append(newSubs, sub)
+					}
+				}
+				t.runImport(ctx, cancel, s.URL, newSubs, []*fd.Group{g})
+				return
 			}
-		}
 
-		db.SortFeed(loadedFeeds)
-		t.resetFeeds(loadedFeeds)
-		db.SortGroup(loadedGroups)
-		t.resetGroups(loadedGroups)
-
-		wasFocusItemWidget := t.ItemWidget.HasFocus()
-		t.focusLeftTable(t.CurrentLeftTable)
-		if wasFocusItemWidget {
-			t.setFocus(t.ItemWidget.Box)
-		}
+			t.IsLoading = false
+			cancel()
+			t.cancelRefresh = nil
+			switch {
+			case err != nil:
+				t.Notify(err.Error(), true)
+			case ctx.Err() != nil:
+				t.Notify("Import cancelled.", false)
+			default:
+				// The mailbox has a single list or sender.
+				for _, sub := range subs {
+					t.fetchNewFeed(sub)
+				}
+			}
+			t.startQueuedMailbox()
+		})
+	}()
+}
 
-		t.App.Draw()
+// startQueuedMailbox reads the next mailbox which was added during a refresh
+// or an import. It must be called from the UI goroutine.
+func (t *Tui) startQueuedMailbox() {
+	if len(t.queuedMailboxes) == 0 || t.cancelRefresh != nil {
+		return
 	}
+	s := t.queuedMailboxes[0]
+	t.queuedMailboxes = t.queuedMailboxes[1:]
+	t.startMailboxImport(s)
+}
 
-	t.IsLoading = false
-
-	db.SortGroup(t.DB.Group)
-	t.resetGroups(t.DB.Group)
-	db.SortFeed(t.DB.Feed)
-	t.resetFeeds(t.DB.Feed)
-
-	if t.ItemWidget.HasFocus() {
-		t.focusLeftTable(t.CurrentLeftTable)
-		t.setFocus(t.ItemWidget.Box)
+// runImport fetches the feeds of subs for an import started with ctx.
+func (t *Tui) runImport(ctx context.Context, cancel context.CancelFunc, path string, subs []*db.Subscription, groups []*fd.Group) {
+	jobs := []*refresh.Job{}
+	for _, s := range subs {
+		jobs = append(jobs, t.Config.NewFeedJob(s, t.Config.RandomColor()))
 	}
+	n := len(jobs)
 
-	if t.FeedWidget.GetRowCount() > 0 {
-		t.Notify("All feeds are up to date.", false)
-	} else {
-		t.Notify("Hello User! Press [n[] to add the first feed.", false)
-	}
+	engine := t.Config.Engine()
+	go func() {
+		done := 0
+		engine.Run(ctx, jobs, func(r *refresh.Result) {
+			done++
+			i := done
+			t.App.QueueUpdateDraw(func() {
+				t.insertFeed(r)
+				t.Notify(fmt.Sprintf("Importing Feeds...(%d/%d)", i, n), false)
+			})
+		})
+
+		t.App.QueueUpdateDraw(func() {
+			t.IsLoading = false
+			cancel()
+			t.cancelRefresh = nil
+			defer t.startQueuedMailbox()
+			added := []*db.Subscription{}
+			for _, s := range subs {
+				if t.DB.GetFeed(s.URL) != nil {
+					added = append(added, s)
+				}
+			}
+			if err := t.Config.AddRules(added); err != nil {
+				t.Notify("import failed: "+err.Error(), true)
+				return
+			}
+			if ctx.Err() != nil {
+				t.Notify("Import cancelled.", false)
+				return
+			}
+			if err := t.DB.AddImportedGroups(groups); err != nil {
+				t.Notify("import failed: "+err.Error(), true)
+				return
+			}
+			db.SortGroup(t.DB.Group)
+			t.resetGroups(t.DB.Group)
+			t.Notify("Imported from "+path+".", false)
+		})
+	}()
+}
 
-	return nil
+// showFeedPicker lets the user choose which of the feeds found on a web page
+// to add.
+func (t *Tui) showFeedPicker(candidates []*fd.Candidate) {
+	t.PickerWidget.Clear()
+	for i, c := range candidates {
+		t.PickerWidget.SetCell(i, 0, tview.NewTableCell(tview.Escape(c.Title)).SetReference(c))
+		t.PickerWidget.SetCell(i, 1, tview.NewTableCell(tview.Escape(c.URL)).SetTextColor(tcell.ColorGray))
+	}
+	t.PickerWidget.Select(0, 0)
+
+	t.Pages.ShowPage(pickerPage)
+	t.App.SetFocus(t.PickerWidget)
+	t.Help([][]string{
+		{"Enter", "add"},
+		{"Esc", "cancel"},
+	})
+	t.Notify(fmt.Sprintf("%d feeds were found. Select one to add.", len(candidates)), false)
 }
 
 func (t *Tui) focusLeftTable(enum int) {
@@ -369,7 +494,7 @@ func (t *Tui) resetFeeds(feeds []*fd.Feed) {
 		cell := t.FeedWidget.GetCell(i, 0)
 		ref, ok := cell.GetReference().(*FeedCellRef)
 		if ok {
-			feedCellRefList[cell.Text] = ref
+			feedCellRefList[ref.Feed.FeedLink] = ref
 		}
 	}
 
@@ -377,7 +502,8 @@ func (t *Tui) resetFeeds(feeds []*fd.Feed) {
 
 	for _, f := range feeds {
 		cell := t.FeedWidget.setCell(f)
-		if cellRef, ok := feedCellRefList[cell.Text]; ok {
+		if cellRef, ok := feedCellRefList[f.FeedLink]; ok {
+			cellRef.Feed = f
 			cell.SetReference(cellRef)
 		}
 	}
@@ -393,7 +519,7 @@ func (t *Tui) resetGroups(groups []*fd.Group) {
 		cell := t.GroupWidget.GetCell(i, 0)
 		ref, ok := cell.GetReference().(*GroupCellRef)
 		if ok {
-			groupCellRefList[cell.Text] = ref
+			groupCellRefList[ref.Group.Title] = ref
 		}
 	}
 
@@ -404,32 +530,83 @@ func (t *Tui) resetGroups(groups []*fd.Group) {
 	}
 
 	for _, g := range groups {
-		cell := t.GroupWidget.setCell(g)
-		if cellRef, ok := groupCellRefList[cell.Text]; ok {
+		cell := t.GroupWidget.setCell(g, t.DB.UnreadCount(g))
+		if cellRef, ok := groupCellRefList[g.Title]; ok {
+			cellRef.Group = g
 			cell.SetReference(cellRef)
 		}
 	}
 }
 
+// markItems sets the read state of items, saves it into the stored records
+// of the feeds they belong to and refreshes the unread counts.
+func (t *Tui) markItems(items []*fd.Item, read bool) error {
+	changedFeeds := map[*fd.Feed]map[string]bool{}
+	for _, i := range items {
+		if i.IsRead == read {
+			continue
+		}
+		i.IsRead = read
+		if parent := t.DB.GetItemParent(i); parent.Feed != nil {
+			if changedFeeds[parent] == nil {
+				changedFeeds[parent] = map[string]bool{}
+			}
+			changedFeeds[parent][i.Key()] = true
+		}
+	}
+
+	for f, keys := range changedFeeds {
+		if err := db.MarkItems(f, keys, read); err != nil {
+			return err
+		}
+	}
+
+	if len(changedFeeds) > 0 {
+		t.updateUnreadCounts()
+	}
+
+	return nil
+}
+
+func (t *Tui) updateUnreadCounts() {
+	for i := 0; i < t.FeedWidget.GetRowCount(); i++ {
+		cell := t.FeedWidget.GetCell(i, 0)
+		if ref, ok := cell.GetReference().(*FeedCellRef); ok {
+			cell.SetText(feedCellText(ref.Feed))
+		}
+	}
+	for i := 0; i < t.GroupWidget.GetRowCount(); i++ {
+		cell := t.GroupWidget.GetCell(i, 0)
+		if ref, ok := cell.GetReference().(*GroupCellRef); ok {
+			cell.SetText(withUnreadCount(ref.Group.Title, t.DB.UnreadCount(ref.Group)))
+		}
+	}
+	t.ItemWidget.updateReadState()
+}
+
 func (t *Tui) Run() error {
 
 	if err := t.DB.LoadFeeds(); err != nil {
-		return err
+		if _, ok := err.(*db.LoadError); !ok {
+			return err
+		}
+		t.Notify(err.Error(), true)
+	}
+
+	db.SortGroup(t.DB.Group)
+	t.resetGroups(t.DB.Group)
+	t.resetFeeds(t.DB.Feed)
+	if t.FeedWidget.GetRowCount() == 0 {
+		t.Notify("Hello User! Press [n[] to add the first feed.", false)
 	}
 
 	if len(t.DB.Group) > 0 {
-		t.setFocus(t.GroupWidget.Table.Box)
+		t.focusLeftTable(enumGroupWidget)
 	} else {
-		t.setFocus(t.FeedWidget.Table.Box)
+		t.focusLeftTable(enumFeedWidget)
 	}
 
-	go func() {
-		if err := t.UpdateAllFeed(); err != nil {
-			panic(err)
-		}
-
-		t.App.QueueUpdateDraw(func() {})
-	}()
+	go t.autoRefresh()
 
 	if err := t.App.Run(); err != nil {
 		t.App.Stop()
@@ -4,11 +4,24 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/yitose/rssviewer/internal/cli"
+	"github.com/yitose/rssviewer/internal/db"
 	"github.com/yitose/rssviewer/internal/tui"
 )
 
 func run() int {
-	if err := tui.NewTui().Run(); err != nil {
+	defer db.CloseStore()
+
+	if len(os.Args) > 1 {
+		return cli.Run(os.Args[1:])
+	}
+
+	t, err := tui.NewTui()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := t.Run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func IsFile(filename string) bool {
@@ -23,17 +24,35 @@ func IsDir(path string) bool {
 	return true
 }
 
+// SaveBytes writes data to a temporary file next to path and renames it over
+// path, so that a crash never leaves a partially written file behind.
 func SaveBytes(data []byte, path string) error {
-	file, err := os.Create(path)
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer file.Close()
-	_, err = file.Write(data)
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func CopyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return nil
+	return SaveBytes(data, dst)
 }
 
 func DirWalk(dir string) []string {
@@ -105,3 +124,13 @@ func RemoveDuplicate(arr []string) []string {
 	}
 	return results
 }
+
+// ExpandHome replaces a leading "~/" in path with the home directory.
+func ExpandHome(path string) string {
+	if rest := strings.TrimPrefix(path, "~/"); rest != path {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}